@@ -0,0 +1,75 @@
+package xmldom
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Dump writes an outline of the document to stderr, for debugging. It is not a serialization:
+// elements are listed one per line with their attributes and quoted text, indented by depth.
+// Partially constructed trees, with nil nodes or nil document pointers, are dumped as far as
+// they go.
+func (d *Document) Dump() {
+	if d == nil {
+		fmt.Fprintln(os.Stderr, "<nil document>")
+		return
+	}
+	if d.ProcInst != "" {
+		fmt.Fprintln(os.Stderr, d.ProcInst)
+	}
+	if d.Prolog != nil {
+		for _, item := range d.Prolog {
+			dumpNode(os.Stderr, item, 0)
+		}
+	} else {
+		for _, directive := range d.Directives {
+			fmt.Fprintln(os.Stderr, directive)
+		}
+	}
+	dumpNode(os.Stderr, d.Root, 0)
+	for _, item := range d.Epilog {
+		dumpNode(os.Stderr, item, 0)
+	}
+}
+
+// Dump writes an outline of the node and its descendants to stderr, see Document.Dump.
+func (n *Node) Dump() {
+	dumpNode(os.Stderr, n, 0)
+}
+
+func dumpNode(w io.Writer, n *Node, level int) {
+	indent := strings.Repeat("  ", level)
+	if n == nil {
+		fmt.Fprintf(w, "%s<nil node>\n", indent)
+		return
+	}
+	n.ensureChildren()
+	switch n.Type {
+	case ProcInstNode:
+		fmt.Fprintf(w, "%s?%s %s\n", indent, n.Name, n.Text)
+		return
+	case DirectiveNode:
+		fmt.Fprintf(w, "%s!%s\n", indent, n.Text)
+		return
+	}
+	fmt.Fprintf(w, "%s%s", indent, n.QualifiedName())
+	if n.NamespaceURI != "" {
+		fmt.Fprintf(w, " {%s}", n.NamespaceURI)
+	}
+	for _, attr := range n.Attributes {
+		if attr == nil {
+			fmt.Fprint(w, " <nil attribute>")
+			continue
+		}
+		fmt.Fprintf(w, " %s=%q", attr.Name, attr.Value)
+	}
+	fmt.Fprintln(w)
+	if n.Text != "" {
+		fmt.Fprintf(w, "%s  %q\n", indent, n.Text)
+	}
+	for _, c := range n.Children {
+		dumpNode(w, c, level+1)
+	}
+}
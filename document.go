@@ -2,6 +2,14 @@ package xmldom
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
 const (
@@ -22,43 +30,472 @@ func NewDocument(name string) *Document {
 type Document struct {
 	ProcInst   string
 	Directives []string
-	Root       *Node
+	// Prolog holds the processing instructions and directives between the XML declaration in
+	// ProcInst and the root, in document order, as ProcInstNode and DirectiveNode nodes. The
+	// parser fills Directives as well, but when the Prolog is set, it is what gets serialized;
+	// the Directives are only serialized for documents without Prolog, such as those built in
+	// code. So edit the Prolog of a parsed document rather than its Directives.
+	Prolog []*Node
+	Root   *Node
+	// Epilog holds the processing instructions following the root, in document order, as
+	// ProcInstNode nodes. They are serialized after the root.
+	Epilog []*Node
+	// Source is the name of the file the document was parsed from, if any.
+	Source string
+	// PreservedWhitespace tells if the document was parsed with whitespace preserved in the text.
+	PreservedWhitespace bool
+	warnings            []string
+	// warningsMu guards the warnings and the lazyErr of a document with deferred children, which
+	// add theirs when materialized, possibly from several goroutines at once
+	warningsMu *sync.Mutex
+	lazyErr    error
+	rawProlog  string
+}
+
+// SetProlog sets the prolog of the document to raw text, written verbatim before the root when
+// serializing, for documents that need an exact header. It takes precedence over the structured
+// prolog: while set, the ProcInst, Prolog and Directives are not written at all, so the text must
+// hold the XML declaration, if any, itself. Nothing is added to it, not even a newline when
+// pretty printing, and it is not checked in any way. OmitDeclaration leaves it out as a whole.
+// Setting the empty string restores the structured prolog.
+func (d *Document) SetProlog(s string) {
+	d.rawProlog = s
 }
 
 func (d *Document) XML() string {
-	buf := new(bytes.Buffer)
-	buf.WriteString(d.ProcInst)
-	for _, directive := range d.Directives {
-		buf.WriteString(directive)
-	}
-	buf.WriteString(d.Root.XML())
-	return buf.String()
+	return NewDOMSerializer().Serialize(d)
 }
 
 func (d *Document) XMLPretty() string {
-	buf := new(bytes.Buffer)
-	if len(d.ProcInst) > 0 {
-		buf.WriteString(d.ProcInst)
-		buf.WriteByte('\n')
+	return NewDOMSerializer().Indent("  ").Serialize(d)
+}
+
+func (d *Document) XMLPrettyEx(indent string) string {
+	return NewDOMSerializer().Indent(indent).Serialize(d)
+}
+
+// Warnings returns the recoverable anomalies found while parsing the document, when parsed with
+// the CollectWarnings option.
+func (d *Document) Warnings() []string {
+	if d.warningsMu != nil {
+		d.warningsMu.Lock()
+		defer d.warningsMu.Unlock()
 	}
-	for _, directive := range d.Directives {
-		buf.WriteString(directive)
-		buf.WriteByte('\n')
+	return d.warnings
+}
+
+// addWarnings appends the warnings of a later parse, such as that of deferred children.
+func (d *Document) addWarnings(warnings []string) {
+	if len(warnings) == 0 {
+		return
 	}
-	buf.WriteString(d.Root.XMLPretty())
-	return buf.String()
+	if d.warningsMu != nil {
+		d.warningsMu.Lock()
+		defer d.warningsMu.Unlock()
+	}
+	d.warnings = append(d.warnings, warnings...)
 }
 
-func (d *Document) XMLPrettyEx(indent string) string {
-	buf := new(bytes.Buffer)
-	if len(d.ProcInst) > 0 {
-		buf.WriteString(d.ProcInst)
-		buf.WriteByte('\n')
+// Err returns the error parsing the deferred children of an element, for a document parsed with
+// LazyDepth, or nil. The input is checked completely while parsing, so this is not expected to
+// happen; the children of such an element are left empty. Only the first error is kept.
+func (d *Document) Err() error {
+	if d.warningsMu != nil {
+		d.warningsMu.Lock()
+		defer d.warningsMu.Unlock()
+	}
+	return d.lazyErr
+}
+
+// setLazyErr records an error parsing deferred children, unless there is one already.
+func (d *Document) setLazyErr(err error) {
+	if d.warningsMu != nil {
+		d.warningsMu.Lock()
+		defer d.warningsMu.Unlock()
+	}
+	if d.lazyErr == nil {
+		d.lazyErr = err
+	}
+}
+
+// RootNamed returns the root of the document, provided its local name is the expected one. This
+// guards format specific processing against documents of another format.
+func (d *Document) RootNamed(expected string) (*Node, error) {
+	if d.Root == nil {
+		return nil, fmt.Errorf("xmldom: expected root <%s>, got none", expected)
+	}
+	if d.Root.Name != expected {
+		return nil, fmt.Errorf("xmldom: expected root <%s>, got <%s>", expected, d.Root.Name)
+	}
+	return d.Root, nil
+}
+
+// XMLCompact serializes the document in its smallest form, on a single line. There is no
+// whitespace between elements, and the leading and trailing whitespace of text is trimmed,
+// except within the scope of an xml:space="preserve" attribute, where text is kept as is.
+func (d *Document) XMLCompact() string {
+	return NewDOMSerializer().Compact(true).Serialize(d)
+}
+
+// Clone makes a deep copy of the document, which can be changed without affecting the original.
+func (d *Document) Clone() *Document {
+	c := &Document{
+		ProcInst:   d.ProcInst,
+		Directives: append([]string(nil), d.Directives...),
+		Source:     d.Source,
+
+		PreservedWhitespace: d.PreservedWhitespace,
+		warnings:            append([]string(nil), d.Warnings()...),
+		rawProlog:           d.rawProlog,
+	}
+	for _, item := range d.Prolog {
+		item := item.Clone()
+		item.Document = c
+		c.Prolog = append(c.Prolog, item)
+	}
+	for _, item := range d.Epilog {
+		item := item.Clone()
+		item.Document = c
+		c.Epilog = append(c.Epilog, item)
+	}
+	if d.Root != nil {
+		c.Root = d.Root.Clone()
+		reindex(c, c.Root)
+	}
+	c.lazyErr = d.Err()
+	return c
+}
+
+// MarshalText implements encoding.TextMarshaler, producing the same XML text as XML.
+func (d *Document) MarshalText() ([]byte, error) {
+	if d.Root == nil {
+		return nil, errors.New("xmldom: document has no root element")
+	}
+	return []byte(d.XML()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the XML text with default parser
+// settings. Any prior content of the document is replaced.
+func (d *Document) UnmarshalText(text []byte) error {
+	doc, err := Parse(bytes.NewReader(text))
+	if err != nil {
+		return err
+	}
+	*d = *doc
+	d.Reindex()
+	return nil
+}
+
+// Reindex walks the tree and repairs the Parent and Document pointers of all nodes, based on the
+// current structure of the Children slices. Call it after bulk manual edits of the tree.
+func (d *Document) Reindex() {
+	for _, item := range d.Prolog {
+		item.Document, item.Parent = d, nil
+	}
+	for _, item := range d.Epilog {
+		item.Document, item.Parent = d, nil
+	}
+	if d.Root == nil {
+		return
+	}
+	d.Root.Parent = nil
+	reindex(d, d.Root)
+}
+
+func reindex(d *Document, n *Node) {
+	n.Document = d
+	for _, c := range n.Children {
+		c.Parent = n
+		reindex(d, c)
+	}
+}
+
+// WriteTo writes the XML text of the document to the writer, as XML would return it. It
+// implements io.WriterTo, returning the number of bytes written.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	return new(domSerializerSettings).writeDocument(w, d)
+}
+
+// WriteFile writes the XML text of the document to the named file. See WriteFilePretty.
+func (d *Document) WriteFile(filename string) error {
+	return NewDOMSerializer().WriteFile(filename, d)
+}
+
+// WriteFilePretty writes the pretty XML text of the document to the named file. The file is
+// written atomically: the text is written and synced to a temporary file in the same directory,
+// which is then renamed over the target. An existing target keeps its permission bits, a new
+// one is created with mode 0644.
+func (d *Document) WriteFilePretty(filename string) error {
+	return NewDOMSerializer().Indent("  ").WriteFile(filename, d)
+}
+
+func writeFileAtomic(filename string, data []byte) (err error) {
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Chmod(perm); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// DocumentStats holds metrics about the complexity of a document.
+type DocumentStats struct {
+	// Nodes counts all nodes in the tree: elements and processing instructions, and the text
+	// held by elements.
+	Nodes int
+	// Elements counts the element nodes.
+	Elements int
+	// Attributes counts the attributes on all elements.
+	Attributes int
+	// MaxDepth is the deepest element nesting level, where the root is at depth 1.
+	MaxDepth int
+}
+
+// Stats gathers the metrics of the document in a single traversal.
+func (d *Document) Stats() DocumentStats {
+	var stats DocumentStats
+	if d.Root != nil {
+		gatherStats(&stats, d.Root, 1)
+	}
+	return stats
+}
+
+func gatherStats(stats *DocumentStats, n *Node, depth int) {
+	n.ensureChildren()
+	stats.Nodes++
+	if n.Type != ElementNode {
+		return
+	}
+	stats.Elements++
+	stats.Attributes += len(n.Attributes)
+	if len(n.Text) > 0 {
+		stats.Nodes++
+	}
+	if depth > stats.MaxDepth {
+		stats.MaxDepth = depth
+	}
+	for _, c := range n.Children {
+		gatherStats(stats, c, depth+1)
+	}
+}
+
+// ElementNames returns the distinct names of the elements in the document, sorted.
+func (d *Document) ElementNames() []string {
+	return d.gatherNames(func(n *Node, add func(string)) {
+		add(n.Name)
+	})
+}
+
+// AttributeNames returns the distinct names of the attributes on all elements in the document,
+// sorted. Namespace declarations are included, as they are attributes to the DOM.
+func (d *Document) AttributeNames() []string {
+	return d.gatherNames(func(n *Node, add func(string)) {
+		for _, attr := range n.Attributes {
+			add(attr.Name)
+		}
+	})
+}
+
+// gatherNames collects the names the given function adds for each element into a sorted set.
+func (d *Document) gatherNames(names func(n *Node, add func(string))) []string {
+	set := make(map[string]bool)
+	add := func(name string) {
+		set[name] = true
+	}
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		n.ensureChildren()
+		if n.Type != ElementNode {
+			return
+		}
+		names(n, add)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	if d.Root != nil {
+		walk(d.Root)
+	}
+
+	result := make([]string, 0, len(set))
+	for name := range set {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// EqualIgnoringPrefixes tells if the documents hold the same tree, regardless of the prefixes
+// used for the namespaces. Elements are equal when they have the same namespace URI and local
+// name, the same text, equal attributes and equal children in the same order. Attributes are
+// equal by namespace URI, local name and value, in any order, where namespace declarations are
+// left out as they only bind the prefixes. Processing instructions within elements are equal by
+// target and instruction. The prolog of the documents is not compared. Names are resolved by
+// the NamespaceURI of the nodes, which the parser sets, but nodes created programmatically may
+// lack.
+func (d *Document) EqualIgnoringPrefixes(other *Document) bool {
+	if d.Root == nil || other.Root == nil {
+		return d.Root == nil && other.Root == nil
+	}
+	return equalIgnoringPrefixes(d.Root, other.Root)
+}
+
+func equalIgnoringPrefixes(a, b *Node) bool {
+	a.ensureChildren()
+	b.ensureChildren()
+	if a.Type != b.Type || a.Text != b.Text {
+		return false
+	}
+	if a.Type == ProcInstNode {
+		return a.Name == b.Name
+	}
+	if a.NamespaceURI != b.NamespaceURI || localName(a.Name) != localName(b.Name) {
+		return false
+	}
+
+	type attrKey struct{ uri, local string }
+	attrs := make(map[attrKey]string)
+	for _, attr := range a.Attributes {
+		if !isNamespaceDecl(attr) {
+			attrs[attrKey{attr.NamespaceURI, localName(attr.Name)}] = attr.Value
+		}
+	}
+	for _, attr := range b.Attributes {
+		if isNamespaceDecl(attr) {
+			continue
+		}
+		key := attrKey{attr.NamespaceURI, localName(attr.Name)}
+		if value, ok := attrs[key]; !ok || value != attr.Value {
+			return false
+		}
+		delete(attrs, key)
+	}
+	if len(attrs) > 0 {
+		return false
+	}
+
+	if len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i, c := range a.Children {
+		if !equalIgnoringPrefixes(c, b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CleanWhitespace clears the text of the elements that hold only whitespace, outside the
+// xml:space="preserve" scopes, so that it does not end up in the output. Text with other
+// characters is kept as it is.
+func (d *Document) CleanWhitespace() {
+	if d.Root != nil {
+		cleanWhitespace(d.Root, false)
+	}
+}
+
+func cleanWhitespace(n *Node, preserve bool) {
+	if n.Type != ElementNode {
+		return
+	}
+	n.ensureChildren()
+	preserve = preserveSpace(n, preserve)
+	if !preserve && strings.TrimSpace(n.Text) == "" {
+		n.Text, n.rawText = "", ""
+	}
+	for _, c := range n.Children {
+		cleanWhitespace(c, preserve)
+	}
+}
+
+// ValidateIDs checks that the IDs of the elements are unique, as FindByID and references rely on
+// that. The IDs are the values of the attributes with the given names, by default id and xml:id.
+// An element holding the same ID in several of these attributes counts once. The error reports
+// each duplicate ID, in document order, with the paths of the elements sharing it.
+func (d *Document) ValidateIDs(names ...string) error {
+	if len(names) == 0 {
+		names = []string{"id", "xml:id"}
+	}
+	elements := make(map[string][]*Node)
+	var ids []string
+	if d.Root != nil {
+		d.Root.Walk(func(n *Node) {
+			for _, name := range names {
+				attr := n.GetAttribute(name)
+				if attr == nil {
+					continue
+				}
+				holders := elements[attr.Value]
+				if len(holders) == 0 {
+					ids = append(ids, attr.Value)
+				} else if holders[len(holders)-1] == n {
+					continue
+				}
+				elements[attr.Value] = append(holders, n)
+			}
+		})
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if holders := elements[id]; len(holders) > 1 {
+			paths := make([]string, len(holders))
+			for i, n := range holders {
+				paths[i] = elementPath(n)
+			}
+			errs = append(errs, fmt.Errorf("xmldom: duplicate ID %q on %s", id, strings.Join(paths, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// elementPath returns the path of the element from the root, such as /root/item[2], where the
+// position is given among the siblings of the same name when there are several.
+func elementPath(n *Node) string {
+	var steps []string
+	for ; n != nil; n = n.Parent {
+		step := n.Name
+		if n.Parent != nil {
+			position, count := 0, 0
+			for _, c := range n.Parent.Children {
+				if c.Type == ElementNode && c.Name == n.Name {
+					if count++; c == n {
+						position = count
+					}
+				}
+			}
+			if count > 1 {
+				step = fmt.Sprintf("%s[%d]", n.Name, position)
+			}
+		}
+		steps = append(steps, step)
 	}
-	for _, directive := range d.Directives {
-		buf.WriteString(directive)
-		buf.WriteByte('\n')
+	var b strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		b.WriteString("/" + steps[i])
 	}
-	buf.WriteString(d.Root.XMLPrettyEx(indent))
-	return buf.String()
+	return b.String()
 }
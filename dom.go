@@ -2,19 +2,25 @@
 package xmldom
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
+	"sync"
+	"unicode"
 )
 
 const (
 	xmlPrefix   = "xml"
 	xmlUrl      = "http://www.w3.org/XML/1998/namespace"
 	xmlnsPrefix = "xmlns"
-	xmlnsUrl    = "http://www.w3.org/2000/xmlns"
+	xmlnsUrl    = "http://www.w3.org/2000/xmlns/"
 	xlinkPrefix = "xlink"
 	xlinkUrl    = "http://www.w3.org/1999/xlink"
 	xsiPrefix   = "xsi"
@@ -27,14 +33,52 @@ const (
 type DOMParser interface {
 	ParseXML(s string) (*Document, error)
 	ParseFile(filename string) (*Document, error)
+	ParseFiles(filenames []string, concurrency int) (map[string]*Document, error)
+	ParseFS(fsys fs.FS, name string) (*Document, error)
 	Parse(r io.Reader) (*Document, error)
+	ParseInto(doc *Document, r io.Reader) error
 	PreserveWhitespace(f bool) DOMParser
+	RetainRawText(f bool) DOMParser
+	IgnoreWhitespaceText(f bool) DOMParser
+	AutoDecompress(f bool) DOMParser
+	NamespacePrefixes(prefixes map[string]string) DOMParser
+	MaxNodes(n int) DOMParser
+	CollectWarnings(f bool) DOMParser
+	StrictProlog(f bool) DOMParser
+	MaxBytes(n int64) DOMParser
+	DefaultNamespace(uri string) DOMParser
+	LazyDepth(depth int) DOMParser
+	Lenient(f bool) DOMParser
+	CharDataJoiner(join func(existing, next string) string) DOMParser
+	PreserveOriginalPrefixes(f bool) DOMParser
 }
 
 type domParserSettings struct {
 	preserveWhitespace bool
+	retainRawText      bool
+	ignoreWhitespace   bool
+	autoDecompress     bool
+	namespacePrefixes  map[string]string
+	maxNodes           int
+	collectWarnings    bool
+	strictProlog       bool
+	maxBytes           int64
+	defaultNamespace   string
+	lazyDepth          int
+	lenient            bool
+	charDataJoiner     func(existing, next string) string
+	originalPrefixes   bool
 }
 
+var (
+	// ErrMaxNodes is returned when parsing a document with more nodes than the parser allows.
+	ErrMaxNodes = errors.New("xmldom: document exceeds the maximum number of nodes")
+	// ErrMaxBytes is returned when parsing an input larger than the parser allows.
+	ErrMaxBytes = errors.New("xmldom: input exceeds maximum size")
+	// ErrNotFound is returned by ParseElement when the input has no element of the name.
+	ErrNotFound = errors.New("xmldom: element not found")
+)
+
 func NewDOMParser() DOMParser {
 	return &domParserSettings{}
 }
@@ -44,6 +88,83 @@ func (s *domParserSettings) PreserveWhitespace(f bool) DOMParser {
 	return s
 }
 
+// RetainRawText makes the parser keep the untrimmed text of the nodes next to the trimmed Text,
+// available through Node.RawText. It has no effect when whitespace is preserved, as the Text is
+// raw already.
+func (s *domParserSettings) RetainRawText(f bool) DOMParser {
+	s.retainRawText = f
+	return s
+}
+
+// IgnoreWhitespaceText makes the parser drop text that consists solely of whitespace, such as the
+// indentation between the elements of a pretty printed source, rather than letting it replace
+// the text of the element. Within the scope of an xml:space="preserve" attribute, whitespace text
+// is significant and kept verbatim.
+func (s *domParserSettings) IgnoreWhitespaceText(f bool) DOMParser {
+	s.ignoreWhitespace = f
+	return s
+}
+
+// AutoDecompress makes the parser sniff the input for the gzip magic bytes, and transparently
+// decompress it when found. Input that is not gzip compressed is parsed as is.
+func (s *domParserSettings) AutoDecompress(f bool) DOMParser {
+	s.autoDecompress = f
+	return s
+}
+
+// NamespacePrefixes registers namespace URI to prefix mappings, used to name attributes in those
+// namespaces. Without a mapping, attributes are named with the prefix that the well known
+// namespaces commonly use, or else with the prefix the source declared for the namespace. Note
+// that a mapping only renames the attributes, it does not add the matching xmlns declaration.
+// Mapping the default namespace to the empty prefix names its attributes by their local name.
+func (s *domParserSettings) NamespacePrefixes(prefixes map[string]string) DOMParser {
+	s.namespacePrefixes = prefixes
+	return s
+}
+
+// MaxNodes limits the number of nodes a document may have, failing the parse with ErrMaxNodes
+// beyond that. Each start element, char data (including CDATA sections and whitespace), comment,
+// processing instruction and directive counts as a node, whether the DOM retains it or not; so
+// the limit can not be bypassed by, for example, flooding the document with comments. Zero, the
+// default, means unlimited.
+func (s *domParserSettings) MaxNodes(n int) DOMParser {
+	s.maxNodes = n
+	return s
+}
+
+// CollectWarnings makes the parser record the recoverable anomalies in the input, available
+// through Document.Warnings: char data outside the root element, dropped comments and duplicate
+// attributes, each with the byte offset it was found at.
+func (s *domParserSettings) CollectWarnings(f bool) DOMParser {
+	s.collectWarnings = f
+	return s
+}
+
+// StrictProlog makes the parser fail on char data outside the root element, in the prolog or
+// after the root is closed, which normally is ignored. Whitespace is allowed there, as the XML
+// specification does.
+func (s *domParserSettings) StrictProlog(f bool) DOMParser {
+	s.strictProlog = f
+	return s
+}
+
+// MaxBytes limits the size of the input, failing the parse with ErrMaxBytes when it holds more
+// bytes than that. The limit applies to the raw input, before any decompression. Zero, the
+// default, means unlimited.
+func (s *domParserSettings) MaxBytes(n int64) DOMParser {
+	s.maxBytes = n
+	return s
+}
+
+// DefaultNamespace puts the unprefixed elements in the given namespace, as if the input were
+// wrapped in an element declaring it as the default namespace. This is meant for fragments taken
+// out of a larger document. A default namespace declared in the input, including an undeclaring
+// xmlns="", still overrides it within its scope. Unprefixed attributes remain in no namespace.
+func (s *domParserSettings) DefaultNamespace(uri string) DOMParser {
+	s.defaultNamespace = uri
+	return s
+}
+
 // Must parse without error, else panic. Helpful when there is no other path to following
 // if the XML source is invalid.
 func Must(doc *Document, err error) *Document {
@@ -65,6 +186,12 @@ func (s *domParserSettings) ParseXML(text string) (*Document, error) {
 
 // ParseFile XML text, using default parser settings. For backwards compatibility.
 func ParseFile(filename string) (*Document, error) {
+	return NewDOMParser().ParseFile(filename)
+}
+
+// ParseFile XML text, using the parser settings from the receiver. The errors mention the
+// filename, which the document records as its Source.
+func (s *domParserSettings) ParseFile(filename string) (*Document, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -73,20 +200,137 @@ func ParseFile(filename string) (*Document, error) {
 		_ = file.Close()
 	}(file)
 
-	return Parse(file)
+	return s.parseSource(file, filename)
 }
 
-// ParseFile XML text, using the parser settings from the receiver.
-func (s *domParserSettings) ParseFile(filename string) (*Document, error) {
-	file, err := os.Open(filename)
+// parseSource parses the XML text from the reader, which was opened from the named source.
+func (s *domParserSettings) parseSource(r io.Reader, source string) (*Document, error) {
+	doc, err := s.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	doc.Source = source
+	return doc, nil
+}
+
+// ParseFS XML text from the named file in the file system, using default parser settings.
+func ParseFS(fsys fs.FS, name string) (*Document, error) {
+	return NewDOMParser().ParseFS(fsys, name)
+}
+
+// ParseFS XML text from the named file in the file system, using the parser settings from the
+// receiver. This allows parsing embedded files, such as from an embed.FS. Like with ParseFile,
+// the errors mention the name, which the document records as its Source.
+func (s *domParserSettings) ParseFS(fsys fs.FS, name string) (*Document, error) {
+	file, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	defer func(file *os.File) {
+	defer func(file fs.File) {
 		_ = file.Close()
 	}(file)
 
-	return s.Parse(file)
+	return s.parseSource(file, name)
+}
+
+// ParseFiles parses the named files concurrently, using default parser settings.
+func ParseFiles(filenames []string, concurrency int) (map[string]*Document, error) {
+	return NewDOMParser().ParseFiles(filenames, concurrency)
+}
+
+// ParseFiles parses the named files concurrently, using the parser settings from the receiver.
+// At most the given number of files is parsed at the same time. The documents that parsed
+// successfully are returned by filename, even if others failed; the errors of those, which
+// mention the filename, are joined in the order of the filenames.
+func (s *domParserSettings) ParseFiles(filenames []string, concurrency int) (map[string]*Document, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	docs := make([]*Document, len(filenames))
+	errs := make([]error, len(filenames))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				docs[i], errs[i] = s.ParseFile(filenames[i])
+			}
+		}()
+	}
+	for i := range filenames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	result := make(map[string]*Document)
+	for i, doc := range docs {
+		if doc != nil {
+			result[filenames[i]] = doc
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// LazyDepth parses the elements down to the given depth only, where the root is at depth 1, and
+// defers parsing the children of the elements at that depth until they are needed. The input is
+// still read and checked completely, but the deferred subtrees are kept as source bytes, which
+// saves the memory of their nodes while they are not accessed. Zero, the default, parses all.
+//
+// The children of a deferred element are parsed on first use through a Node method, or through
+// the serialization, query and traversal functions of the package; the text of the element is
+// available right away. Reading the Children field directly does not materialize them, so call a
+// method such as FirstChild first. Materialization happens once per element, and is safe for
+// concurrent readers going through the methods. The MaxNodes limit counts the deferred nodes
+// while parsing, so it bounds materialization as well. Malformed deferred content fails the parse
+// like it does without LazyDepth, and Document.Err reports any failure to materialize. With the
+// Lenient option, nothing is deferred, as the source of an element the decoder closed
+// automatically cannot be parsed again on its own.
+func (s *domParserSettings) LazyDepth(depth int) DOMParser {
+	s.lazyDepth = depth
+	return s
+}
+
+// deferredDepth is the depth of the elements whose children are deferred, or zero for none.
+func (s *domParserSettings) deferredDepth() int {
+	if s.lenient {
+		return 0
+	}
+	return s.lazyDepth
+}
+
+// Lenient relaxes the conformance of the parser, for sources that are not quite well-formed. It
+// turns off the Strict mode of the decoder, which then closes mismatched elements automatically,
+// accepts unquoted and valueless attributes, and passes unknown entities through as text. The
+// resulting DOM is a best effort, and the input not necessarily XML. It turns LazyDepth off. By
+// default, parsing is strict.
+func (s *domParserSettings) Lenient(f bool) DOMParser {
+	s.lenient = f
+	return s
+}
+
+// CharDataJoiner sets how the chunks of char data within an element are joined into its text,
+// such as the chunks before and after a child element, a comment or a CDATA section. The join
+// function is called with the text joined so far and the next chunk, both as read, before any
+// whitespace trimming; whitespace only chunks dropped by IgnoreWhitespaceText are not passed.
+// By default, the chunks are concatenated. As long as the join function appends to the text
+// joined so far, the serializer writes the text back between the children where it was read.
+func (s *domParserSettings) CharDataJoiner(join func(existing, next string) string) DOMParser {
+	s.charDataJoiner = join
+	return s
+}
+
+// PreserveOriginalPrefixes names the attributes in the well-known XLink and XML Schema instance
+// namespaces by the prefixes the source declared for them, rather than the canonical xlink and
+// xsi prefixes, so that documents using other prefixes round trip faithfully. The xml prefix is
+// fixed by the XML specification, and the NamespacePrefixes option still takes precedence. By
+// default, the canonical prefixes are used.
+func (s *domParserSettings) PreserveOriginalPrefixes(f bool) DOMParser {
+	s.originalPrefixes = f
+	return s
 }
 
 // Parse the XML text from the given reader, using default parser settings. For backwards compatibility.
@@ -94,74 +338,403 @@ func Parse(r io.Reader) (*Document, error) {
 	return NewDOMParser().Parse(r)
 }
 
+// ParseSafe parses the XML text from the given reader like Parse, but never panics: a panic
+// within the parse is recovered and returned as an error holding its message. It is meant for
+// untrusted input and fuzzing harnesses.
+func ParseSafe(r io.Reader) (doc *Document, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			doc, err = nil, fmt.Errorf("xmldom: panic while parsing: %v", p)
+		}
+	}()
+	return Parse(r)
+}
+
 // Parse the XML text from the given reader, using the parser settings from the receiver.
 func (s *domParserSettings) Parse(r io.Reader) (*Document, error) {
+	b, err := s.newBuilder(r)
+	if err != nil {
+		return nil, err
+	}
+	return b.build(false)
+}
+
+// ParseInto parses the XML text from the given reader into an existing document, using the
+// parser settings from the receiver. The parsed root becomes the root of the document, or, when
+// the document has a root already, is appended to it as its last child. On error, the document
+// is left unchanged.
+func (s *domParserSettings) ParseInto(doc *Document, r io.Reader) error {
+	parsed, err := s.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	if doc.Root != nil {
+		doc.Root.AppendChild(parsed.Root)
+	} else {
+		doc.Root = parsed.Root
+		if doc.ProcInst == "" {
+			doc.ProcInst = parsed.ProcInst
+		}
+		if doc.Prolog == nil && doc.Directives == nil {
+			doc.Directives, doc.Prolog = parsed.Directives, parsed.Prolog
+			for _, item := range doc.Prolog {
+				item.Document = doc
+			}
+		}
+		if doc.Epilog == nil {
+			doc.Epilog = parsed.Epilog
+			for _, item := range doc.Epilog {
+				item.Document = doc
+			}
+		}
+	}
+	if doc.warningsMu == nil {
+		doc.warningsMu = parsed.warningsMu
+	}
+	reindex(doc, parsed.Root)
+	doc.addWarnings(parsed.warnings)
+	return nil
+}
+
+// domBuilder builds documents from the tokens of a decoder, using the parser settings.
+type domBuilder struct {
+	settings *domParserSettings
+	p        *xml.Decoder
+	bom      int64
+	// src holds the input after the byte order mark when parsing with LazyDepth, as the deferred
+	// subtrees refer to it.
+	src []byte
+}
+
+func (s *domParserSettings) newBuilder(r io.Reader) (*domBuilder, error) {
+	if s.maxBytes > 0 {
+		r = &maxBytesReader{r: r, remaining: s.maxBytes + 1}
+	}
+	if s.autoDecompress {
+		var err error
+		if r, err = decompress(r); err != nil {
+			return nil, err
+		}
+	}
+
+	r, bom := stripBOM(r)
+	var src []byte
+	if s.deferredDepth() > 0 {
+		var err error
+		if src, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(src)
+	}
+	return &domBuilder{settings: s, p: s.newDecoder(r), bom: bom, src: src}, nil
+}
+
+// elementText is the char data read for an element while building: the text joined so far, and
+// the segments of the text nodes, with the number of children preceding each. The first segment
+// is kept apart, as most elements have only one. The splits are the lengths of the joined text
+// when each child started, unless unsplit tells the join function rewrote the text before them.
+type elementText struct {
+	joined     string
+	first      string
+	firstAt    int
+	segments   []string
+	segmentsAt []int
+	hasFirst   bool
+	splits     []int
+	unsplit    bool
+}
+
+// splitsIn returns the splits as offsets in the text of the element, which is the joined text,
+// or the joined text with its surrounding whitespace trimmed.
+func (t *elementText) splitsIn(text string) []int {
+	lead := 0
+	if text != t.joined {
+		lead = len(t.joined) - len(strings.TrimLeftFunc(t.joined, unicode.IsSpace))
+	}
+	splits := make([]int, len(t.splits))
+	for i, split := range t.splits {
+		splits[i] = min(max(split-lead, 0), len(text))
+	}
+	return splits
+}
+
+func (t *elementText) addSegment(segment string, at int) {
+	if !t.hasFirst {
+		t.first, t.firstAt, t.hasFirst = segment, at, true
+	} else {
+		t.segments = append(t.segments, segment)
+		t.segmentsAt = append(t.segmentsAt, at)
+	}
+}
+
+// addChild appends the child to the open element.
+func (t *domTree) addChild(child *Node) {
+	t.splitText()
+	t.e.Children = append(t.e.Children, child)
+}
+
+// splitText marks where the next child of the open element splits its text.
+func (t *domTree) splitText() {
+	text := &t.texts[len(t.texts)-1]
+	text.splits = append(text.splits, len(text.joined))
+}
+
+// joinCharData joins the next chunk of char data to the text of an element.
+func (s *domParserSettings) joinCharData(existing, next string) string {
+	if s.charDataJoiner == nil {
+		return existing + next
+	}
+	return s.charDataJoiner(existing, next)
+}
+
+// domTree assembles the nodes of a document as its tokens are read. It is shared by the parser
+// and the TreeBuilder, so that both build the same DOM from the same content.
+type domTree struct {
+	settings *domParserSettings
+	doc      *Document
+	// e is the open element, nil outside of the root
+	e *Node
+	// preserve holds the xml:space scope, and texts the char data read so far, of each open element
+	preserve []bool
+	texts    []elementText
+}
+
+// inheritedPreserve tells if xml:space preserves the whitespace in scope of the open element.
+func (t *domTree) inheritedPreserve() bool {
+	return len(t.preserve) > 0 && t.preserve[len(t.preserve)-1]
+}
+
+// startElement opens a new element as a child of the open one, or as the root.
+func (t *domTree) startElement(el *Node) {
+	inherited := t.inheritedPreserve()
+	el.Document = t.doc
+	el.Parent = t.e
+	if t.e != nil {
+		t.addChild(el)
+	}
+	t.preserve = append(t.preserve, preserveSpace(el, inherited))
+	t.texts = append(t.texts, elementText{})
+	t.e = el
+	if t.doc.Root == nil {
+		t.doc.Root = el
+	}
+}
+
+// endElement closes the open element, recording its text nodes when it has more than one.
+func (t *domTree) endElement() {
+	e := t.e
+	if text := t.texts[len(t.texts)-1]; len(text.segments) > 0 || text.hasFirst && len(e.Children) > 0 {
+		e.textNodes = append([]string{text.first}, text.segments...)
+		e.textNodesAt = append([]int{text.firstAt}, text.segmentsAt...)
+		e.textNodesOf = e.Text
+	}
+	if text := &t.texts[len(t.texts)-1]; e.Text != "" && len(text.splits) > 0 && !text.unsplit {
+		e.textSplits = text.splitsIn(e.Text)
+		e.textNodesOf = e.Text
+	}
+	t.e = e.Parent
+	t.preserve = t.preserve[:len(t.preserve)-1]
+	t.texts = t.texts[:len(t.texts)-1]
+}
+
+// charData adds a chunk of char data to the text of the open element.
+func (t *domTree) charData(chunk string) {
+	s, e, top := t.settings, t.e, len(t.texts)-1
+	if s.ignoreWhitespace && strings.TrimSpace(chunk) == "" && !t.preserve[top] {
+		return
+	}
+	keep := func(text string) bool {
+		return s.preserveWhitespace || s.ignoreWhitespace && t.preserve[top] && strings.TrimSpace(text) == ""
+	}
+	segment := chunk
+	if !keep(segment) {
+		segment = strings.TrimSpace(segment)
+	}
+	if segment != "" {
+		t.texts[top].addSegment(segment, len(e.Children))
+	}
+
+	text := chunk
+	if joined := t.texts[top].joined; joined != "" {
+		text = s.joinCharData(joined, text)
+		if !strings.HasPrefix(text, joined) {
+			t.texts[top].unsplit = true
+		}
+	}
+	t.texts[top].joined = text
+
+	if keep(text) {
+		e.Text = text
+	} else {
+		if s.retainRawText {
+			e.rawText = text
+		}
+		e.Text = strings.TrimSpace(text)
+	}
+}
+
+// procInst adds a processing instruction: as a child of the open element, to the epilog after
+// the root, to the prolog before it, or as the XML declaration.
+func (t *domTree) procInst(target, inst string, start, end int64) {
+	pi := &Node{
+		Document:    t.doc,
+		Type:        ProcInstNode,
+		Name:        target,
+		Text:        inst,
+		StartOffset: start,
+		EndOffset:   end,
+	}
+	switch {
+	case t.e != nil:
+		pi.Parent = t.e
+		t.addChild(pi)
+	case t.doc.Root != nil:
+		t.doc.Epilog = append(t.doc.Epilog, pi)
+	case target != xmlPrefix:
+		t.doc.Prolog = append(t.doc.Prolog, pi)
+	default:
+		t.doc.ProcInst = stringifyProcInst(&xml.ProcInst{Target: target, Inst: []byte(inst)})
+	}
+}
+
+// directive adds a directive to the document, and to its prolog when it precedes the root.
+func (t *domTree) directive(text string, start, end int64) {
+	if t.doc.Root == nil {
+		t.doc.Prolog = append(t.doc.Prolog, &Node{
+			Document:    t.doc,
+			Type:        DirectiveNode,
+			Text:        text,
+			StartOffset: start,
+			EndOffset:   end,
+		})
+	}
+	directive := xml.Directive(text)
+	t.doc.Directives = append(t.doc.Directives, stringifyDirective(&directive))
+}
+
+// newDecoder creates a decoder reading from the given reader, configured by the settings.
+func (s *domParserSettings) newDecoder(r io.Reader) *xml.Decoder {
 	p := xml.NewDecoder(r)
+	p.DefaultSpace = s.defaultNamespace
+	p.Strict = !s.lenient
+	return p
+}
+
+// build the next document from the decoded tokens. All tokens up to the end of the input are
+// read into the document, unless single is set, in which case building stops once the root
+// element is closed. In that case, io.EOF is returned when the input holds no further element.
+func (b *domBuilder) build(single bool) (*Document, error) {
+	s, p, bom := b.settings, b.p, b.bom
+	start := bom + p.InputOffset()
 	t, err := p.Token()
 	if err != nil {
 		return nil, err
 	}
 
 	doc := new(Document)
-	var e *Node
+	doc.PreservedWhitespace = s.preserveWhitespace
+	if s.deferredDepth() > 0 {
+		doc.warningsMu = new(sync.Mutex)
+	}
+	namer := &attributeNamer{prefixes: s.namespacePrefixes, original: s.originalPrefixes, defaultNamespace: s.defaultNamespace}
+	tree := &domTree{settings: s, doc: doc}
+	var nodes int
+	// skip is the element nesting level within the children of a deferred element
+	var skip int
+	warn := func(format string, args ...interface{}) {
+		if s.collectWarnings {
+			doc.warnings = append(doc.warnings, fmt.Sprintf(format, args...))
+		}
+	}
 	for t != nil {
+		if _, ok := t.(xml.EndElement); !ok {
+			if nodes++; s.maxNodes > 0 && nodes > s.maxNodes {
+				return nil, ErrMaxNodes
+			}
+		}
+		if e := tree.e; e != nil && e.lazy != nil {
+			// the content of a deferred element is skipped, except for its own text and end
+			deferred := true
+			switch t.(type) {
+			case xml.StartElement:
+				if skip == 0 {
+					tree.splitText()
+				}
+				skip++
+			case xml.ProcInst:
+				if skip == 0 {
+					tree.splitText()
+				}
+			case xml.EndElement:
+				if deferred = skip > 0; deferred {
+					skip--
+				}
+			case xml.CharData:
+				deferred = skip > 0
+			}
+			if deferred {
+				start = bom + p.InputOffset()
+				t, err = p.Token()
+				continue
+			}
+		}
+
 		switch token := t.(type) {
 		case xml.StartElement:
 			// a new node
 			el := new(Node)
-			el.Document = doc
-			el.Parent = e
 			el.Name = token.Name.Local
-			for _, attr := range token.Attr {
-				var name, ns string
-				if attr.Name.Space != "" {
-					ns = attr.Name.Space
-					switch ns {
-					case xmlnsUrl:
-						name = fmt.Sprintf("%s:%s", xmlnsPrefix, attr.Name.Local)
-					case xmlUrl:
-						name = fmt.Sprintf("%s:%s", xmlPrefix, attr.Name.Local)
-					case xlinkUrl:
-						name = fmt.Sprintf("%s:%s", xlinkPrefix, attr.Name.Local)
-					case xsiUrl:
-						name = fmt.Sprintf("%s:%s", xsiPrefix, attr.Name.Local)
-					default:
-						name = fmt.Sprintf("%s:%s", attr.Name.Space, attr.Name.Local)
+			el.NamespaceURI = token.Name.Space
+			el.Attributes = namer.push(token.Attr)
+			el.Prefix = namer.elementPrefix(token.Name.Space)
+			el.StartOffset = start
+			for i, attr := range el.Attributes {
+				for _, other := range el.Attributes[:i] {
+					if attr.Name == other.Name {
+						warn("duplicate attribute %s on <%s> at offset %d", attr.Name, el.Name, start)
 					}
-				} else {
-					name = attr.Name.Local
 				}
-				el.Attributes = append(el.Attributes, &Attribute{
-					Name:  name,
-					Value: attr.Value,
-				})
-			}
-			if e != nil {
-				e.Children = append(e.Children, el)
 			}
-			e = el
-
-			if doc.Root == nil {
-				doc.Root = e
+			inherited := tree.inheritedPreserve()
+			tree.startElement(el)
+			if len(tree.preserve) == s.deferredDepth() {
+				el.lazy = newLazyChildren(el, s, inherited)
 			}
 		case xml.EndElement:
-			e = e.Parent
+			e := tree.e
+			e.EndOffset = bom + p.InputOffset()
+			if e.lazy != nil {
+				e.lazy.src = b.src[e.StartOffset-bom : e.EndOffset-bom]
+			}
+			tree.endElement()
+			namer.pop()
 		case xml.CharData:
 			// text node
-			if e != nil {
-				if s.preserveWhitespace {
-					e.Text = string(token)
-				} else {
-					e.Text = string(bytes.TrimSpace(token))
+			if tree.e == nil {
+				if len(bytes.TrimSpace(token)) > 0 {
+					if s.strictProlog {
+						return nil, fmt.Errorf("xmldom: char data outside the root element at offset %d", start)
+					}
+					warn("char data outside the root element at offset %d ignored", start)
 				}
+			} else {
+				tree.charData(string(token))
 			}
+		case xml.Comment:
+			warn("comment at offset %d ignored", start)
 		case xml.ProcInst:
-			doc.ProcInst = stringifyProcInst(&token)
+			tree.procInst(token.Target, string(token.Inst), start, bom+p.InputOffset())
 		case xml.Directive:
-			doc.Directives = append(doc.Directives, stringifyDirective(&token))
+			tree.directive(string(token), start, bom+p.InputOffset())
+		}
+
+		if single && tree.e == nil && doc.Root != nil {
+			return doc, nil
 		}
 
 		// get the next token
+		start = bom + p.InputOffset()
 		t, err = p.Token()
 	}
 
@@ -169,7 +742,115 @@ func (s *domParserSettings) Parse(r io.Reader) (*Document, error) {
 	if err != io.EOF {
 		return nil, err
 	}
+	if single && doc.Root == nil {
+		return nil, io.EOF
+	}
 
 	// All is good, return the document
 	return doc, nil
 }
+
+// PeekRoot reads from the given reader only up to the first start element, and returns the name
+// and attributes of the root element without building the DOM. It returns io.EOF when the input
+// holds no element at all.
+func PeekRoot(r io.Reader) (name string, attrs []*Attribute, err error) {
+	if _, ok := r.(io.ByteReader); !ok {
+		r = &byteReader{r: r}
+	}
+	p := xml.NewDecoder(r)
+	for {
+		t, err := p.Token()
+		if err != nil {
+			return "", nil, err
+		}
+		if token, ok := t.(xml.StartElement); ok {
+			return token.Name.Local, new(attributeNamer).push(token.Attr), nil
+		}
+	}
+}
+
+// ExtractOriginal returns the exact bytes the given parsed node was read from, including its
+// original formatting. The node offsets refer to the (decompressed) input of Parse, so src must
+// provide that same input. A source that ends before the end of the node gives an error wrapping
+// io.ErrUnexpectedEOF.
+func ExtractOriginal(src io.ReaderAt, n *Node) ([]byte, error) {
+	if n.StartOffset < 0 || n.StartOffset >= n.EndOffset {
+		return nil, fmt.Errorf("xmldom: invalid offsets [%d, %d) for <%s>", n.StartOffset, n.EndOffset, n.Name)
+	}
+	buf := make([]byte, n.EndOffset-n.StartOffset)
+	read, err := src.ReadAt(buf, n.StartOffset)
+	if read == len(buf) {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, fmt.Errorf("xmldom: reading [%d, %d) for <%s>: %w", n.StartOffset, n.EndOffset, n.Name, err)
+}
+
+// decompress wraps the reader in a gzip reader if the input starts with the gzip magic bytes.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// stripBOM skips the UTF-8 byte order mark the input may start with, returning the reader to
+// continue with and the length of the mark that was skipped.
+func stripBOM(r io.Reader) (*bufio.Reader, int64) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
+		return br, int64(len(utf8BOM))
+	}
+	return br, 0
+}
+
+// maxBytesReader fails with ErrMaxBytes once more than the allowed bytes have been read, while
+// remaining counts down from one beyond the limit.
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining <= 0 {
+		return 0, ErrMaxBytes
+	}
+	if int64(len(p)) > m.remaining {
+		p = p[:m.remaining]
+	}
+	n, err := m.r.Read(p)
+	if m.remaining -= int64(n); m.remaining <= 0 {
+		return n, ErrMaxBytes
+	}
+	return n, err
+}
+
+// byteReader reads a single byte at a time from the wrapped reader. The xml decoder does not add
+// its own buffering to an io.ByteReader, so no more input is consumed than is actually decoded.
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return b.r.Read(p[:1])
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(b.r, b.buf[:]); err != nil {
+		return 0, err
+	}
+	return b.buf[0], nil
+}
@@ -1,9 +1,18 @@
 package xmldom_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"github.com/rtenhove/go-xmldom"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 )
 
 func TestParseNamespaces(t *testing.T) {
@@ -53,6 +62,138 @@ func TestParseNamespaces(t *testing.T) {
 	}
 }
 
+func TestTextBytes(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root><a>text</a><b/></root>`))
+	if b := doc.Root.FirstChild().TextBytes(); string(b) != "text" {
+		t.Fatalf("Expect the bytes of the text but got %q", b)
+	}
+	if b := doc.Root.LastChild().TextBytes(); b != nil {
+		t.Fatalf("Expect nil for empty text but got %q", b)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root><a><b/></a><c/></root>`))
+	a := doc.Root.FirstChild()
+	if a.Detach() != a || a.Parent != nil {
+		t.Fatalf("Expect the detached node without parent")
+	}
+	if len(doc.Root.Children) != 1 || doc.Root.FirstChild().Name != "c" {
+		t.Fatalf("Expect the node removed from its parent but got %s", doc.Root.XML())
+	}
+	if len(a.Children) != 1 || a.FirstChild().Parent != a {
+		t.Fatalf("Expect the subtree of the detached node intact")
+	}
+	if a.Detach() != a || a.Parent != nil {
+		t.Fatalf("Expect detaching a node without parent to be a no-op")
+	}
+}
+
+func TestGetAttributeFold(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<INPUT Type="text"/>`))
+	if v, ok := doc.Root.GetAttributeFold("tYPE"); !ok || v != "text" {
+		t.Fatalf("Expect a case-insensitive match but got %q, %v", v, ok)
+	}
+	if v, ok := doc.Root.GetAttributeFold("name"); ok || v != "" {
+		t.Fatalf("Expect a miss but got %q, %v", v, ok)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root><a/><b/><c/></root>`))
+	for i, c := range doc.Root.Children {
+		if c.Index() != i {
+			t.Fatalf("Expect %s at index %d but got %d", c.Name, i, c.Index())
+		}
+	}
+	if doc.Root.Index() != -1 {
+		t.Fatalf("Expect -1 for a node without parent but got %d", doc.Root.Index())
+	}
+}
+
+func TestAppendByName(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root><item/><x><item/></x><item/></root>`))
+	first := doc.Root.FirstChild()
+	buf := make([]*xmldom.Node, 1, 8)
+	buf[0] = first
+
+	nodes := doc.Root.AppendByName(buf, "item")
+	if &nodes[0] != &buf[0] {
+		t.Fatalf("Expect the buffer of dst to be reused")
+	}
+	found := doc.Root.FindByName("item")
+	if len(nodes) != 1+len(found) || nodes[0] != first {
+		t.Fatalf("Expect the matches appended after the existing element but got %d nodes", len(nodes))
+	}
+	for i, n := range found {
+		if nodes[i+1] != n {
+			t.Fatalf("Expect the same nodes as FindByName at %d", i)
+		}
+	}
+}
+
+// captureStderr returns what the function writes to stderr.
+func captureStderr(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+	f()
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestDumpOutline(t *testing.T) {
+	var nilDoc *xmldom.Document
+	if out := captureStderr(t, nilDoc.Dump); out != "<nil document>\n" {
+		t.Fatalf("Unexpected dump of a nil document %q", out)
+	}
+	if out := captureStderr(t, (&xmldom.Document{}).Dump); out != "<nil node>\n" {
+		t.Fatalf("Unexpected dump of a nil root %q", out)
+	}
+
+	doc := xmldom.Must(xmldom.ParseXML(`<?xml version="1.0"?><!DOCTYPE r><r xmlns:x="urn:x" a="1"><x:c>text</x:c><?pi y?></r>`))
+	doc.Root.Children = append(doc.Root.Children, nil)
+	expected := `<?xml version="1.0"?>
+!DOCTYPE r
+r xmlns:x="urn:x" a="1"
+  x:c {urn:x}
+    "text"
+  ?pi y
+  <nil node>
+`
+	if out := captureStderr(t, doc.Dump); out != expected {
+		t.Fatalf("Unexpected dump\n got: %q\nwant: %q", out, expected)
+	}
+}
+
+func TestInlineShortElementsStopsMeasuringAtLimit(t *testing.T) {
+	s := xmldom.NewDOMSerializer().Indent("  ").InlineShortElements(19).OmitDeclaration(true)
+	// 19 characters in 24 bytes, which fits
+	doc := xmldom.Must(xmldom.ParseXML(`<r><p><b>ééééé</b></p></r>`))
+	if actual, expected := s.Serialize(doc), "<r>\n  <p><b>ééééé</b></p>\n</r>\n"; actual != expected {
+		t.Fatalf("Serialize() = %q, expected %q", actual, expected)
+	}
+	long := strings.Repeat("é", 1000)
+	doc = xmldom.Must(xmldom.ParseXML(`<r><b>` + long + `</b></r>`))
+	if actual, expected := s.Serialize(doc), "<r>\n  <b>"+long+"</b>\n</r>\n"; actual != expected {
+		t.Fatalf("Serialize() = %q, expected %q", actual, expected)
+	}
+	depth := 2000
+	input := strings.Repeat("<a>", depth) + strings.Repeat("</a>", depth)
+	actual := s.Serialize(xmldom.Must(xmldom.ParseXML(input)))
+	if lines := strings.Count(actual, "\n"); lines != 2*(depth-3)+1 {
+		t.Fatalf("Serialize() wrote %d lines, expected %d", lines, 2*(depth-3)+1)
+	}
+	if !strings.Contains(actual, "<a><a><a /></a></a>\n") {
+		t.Fatalf("Serialize() = ...%q, expected the innermost elements on one line", actual[len(actual)/2-40:len(actual)/2+40])
+	}
+}
+
 func TestSvgParse(t *testing.T) {
 	root := xmldom.Must(xmldom.ParseFile("test.svg")).Root
 
@@ -108,4 +249,1084 @@ func TestParserWithPreserveWhitespaceDoesNotTrimCharData(t *testing.T) {
 	if " bar " != doc.Root.Text {
 		t.Fatalf("Expect xml to contain ' bar ' but got '%s'", doc.Root.Text)
 	}
+	if !doc.PreservedWhitespace {
+		t.Fatalf("Expect document to record that whitespace was preserved")
+	}
+}
+
+func TestPeekRootStopsAfterRootStartElement(t *testing.T) {
+	r := strings.NewReader(`<?xml version="1.0"?><svg width="10" xmlns:xlink="http://www.w3.org/1999/xlink"><rect/></svg>`)
+
+	name, attrs, err := xmldom.PeekRoot(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if name != "svg" {
+		t.Fatalf("Expect root name 'svg' but got '%s'", name)
+	}
+	if len(attrs) != 2 || attrs[0].Name != "width" || attrs[1].Name != "xmlns:xlink" {
+		t.Fatalf("Unexpected root attributes: %v", attrs)
+	}
+
+	rest := make([]byte, r.Len())
+	_, _ = r.Read(rest)
+	if string(rest) != "<rect/></svg>" {
+		t.Fatalf("Expect reader to be positioned after the root start element but got '%s'", rest)
+	}
+}
+
+func TestFindByNameNSDistinguishesNamespaces(t *testing.T) {
+	xml := `<root xmlns="urn:a" xmlns:b="urn:b"><item id="1"/><b:item id="2"/><b:group><item id="3"/></b:group></root>`
+	root := xmldom.Must(xmldom.ParseXML(xml)).Root
+
+	if n := len(root.FindByName("item")); n != 3 {
+		t.Fatalf("Expect 3 items by local name but got %d", n)
+	}
+
+	nodes := root.FindByNameNS("urn:b", "item")
+	if len(nodes) != 1 || nodes[0].GetAttributeValue("id") != "2" {
+		t.Fatalf("Expect only item 2 in urn:b but got %v", nodes)
+	}
+
+	nodes = root.FindByNameNS("urn:a", "item")
+	if len(nodes) != 2 || nodes[0].GetAttributeValue("id") != "1" || nodes[1].GetAttributeValue("id") != "3" {
+		t.Fatalf("Expect items 1 and 3 in urn:a but got %v", nodes)
+	}
+}
+
+func TestParserWithAutoDecompress(t *testing.T) {
+	xml := `<foo>bar</foo>`
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	_, _ = zw.Write([]byte(xml))
+	_ = zw.Close()
+
+	dp := xmldom.NewDOMParser().AutoDecompress(true)
+	for _, input := range [][]byte{buf.Bytes(), []byte(xml)} {
+		doc, err := dp.Parse(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if doc.Root.Name != "foo" || doc.Root.Text != "bar" {
+			t.Fatalf("Expect <foo>bar</foo> but got %s", doc.Root.XML())
+		}
+	}
+}
+
+func TestExtractOriginalReturnsSourceBytes(t *testing.T) {
+	xml := `<root><item  a='1' >text<child/></item><empty/></root>`
+	root := xmldom.Must(xmldom.ParseXML(xml)).Root
+	src := strings.NewReader(xml)
+
+	for name, expected := range map[string]string{
+		"item":  `<item  a='1' >text<child/></item>`,
+		"child": `<child/>`,
+		"empty": `<empty/>`,
+		"root":  xml,
+	} {
+		b, err := xmldom.ExtractOriginal(src, root.FindOneByName(name))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Expect '%s' but got '%s'", expected, b)
+		}
+	}
+
+	if _, err := xmldom.ExtractOriginal(src, root.CreateNode("new")); err == nil {
+		t.Fatalf("Expect an error for a node without offsets")
+	}
+	if b, err := xmldom.ExtractOriginal(strings.NewReader(xml[:8]), root.FindOneByName("item")); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expect io.ErrUnexpectedEOF for a truncated source but got %q, %v", b, err)
+	}
+}
+
+func TestReindexRepairsPointers(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root><a><b/></a></root>`))
+	a := doc.Root.FirstChild()
+	b := a.FirstChild()
+
+	// move b directly by editing the slices
+	a.Children = nil
+	doc.Root.Children = append(doc.Root.Children, b)
+	b.Document = nil
+
+	doc.Reindex()
+	if b.Parent != doc.Root || b.Document != doc {
+		t.Fatalf("Expect pointers of moved node to be repaired")
+	}
+}
+
+func TestWriteFilePreservesMode(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "doc.xml")
+	if err := os.WriteFile(filename, []byte("<old/>"), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	doc := xmldom.NewDocument("new")
+	if err := doc.WriteFile(filename); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b, _ := os.ReadFile(filename)
+	if string(b) != doc.XML() {
+		t.Fatalf("Expect file to contain '%s' but got '%s'", doc.XML(), b)
+	}
+	if fi, _ := os.Stat(filename); fi.Mode().Perm() != 0600 {
+		t.Fatalf("Expect mode 0600 to be preserved but got %v", fi.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(filename)); len(entries) != 1 {
+		t.Fatalf("Expect the temporary file to be gone but got %d entries", len(entries))
+	}
+}
+
+func TestParserNamesAttributesWithNamespacePrefixes(t *testing.T) {
+	xml := `<root xmlns:e="http://example.com/ns" e:foo="1" xmlns:o="http://other.com/ns" o:bar="2"></root>`
+
+	root := xmldom.Must(xmldom.ParseXML(xml)).Root
+	if root.GetAttributeValue("e:foo") != "1" || root.GetAttributeValue("o:bar") != "2" {
+		t.Fatalf("Expect attributes named with their declared prefix but got %s", root.XML())
+	}
+
+	dp := xmldom.NewDOMParser().NamespacePrefixes(map[string]string{"http://example.com/ns": "ex"})
+	root = xmldom.Must(dp.ParseXML(xml)).Root
+	if root.GetAttributeValue("ex:foo") != "1" || root.GetAttributeValue("o:bar") != "2" {
+		t.Fatalf("Expect attributes named with the registered prefix but got %s", root.XML())
+	}
+}
+
+func TestParseDefaultNamespaceDeclaration(t *testing.T) {
+	root := xmldom.Must(xmldom.ParseXML(`<root xmlns="http://example.com/"/>`)).Root
+
+	if len(root.Attributes) != 1 {
+		t.Fatalf("Expect a single attribute but got %d", len(root.Attributes))
+	}
+	if attr := root.Attributes[0]; attr.Name != "xmlns" || attr.Value != "http://example.com/" {
+		t.Fatalf("Expect xmlns=\"http://example.com/\" but got %s=\"%s\"", attr.Name, attr.Value)
+	}
+}
+
+func TestXMLCompactKeepsPreservedWhitespace(t *testing.T) {
+	xml := `<root>
+  <a> one </a>
+  <b xml:space="preserve"> two <c> three </c></b>
+</root>`
+	doc := xmldom.Must(xmldom.NewDOMParser().PreserveWhitespace(true).ParseXML(xml))
+	doc.ProcInst = ""
+
+	expected := `<root><a>one</a><b xml:space="preserve"> two <c> three </c></b></root>`
+	if compact := doc.XMLCompact(); compact != expected {
+		t.Fatalf("Expect '%s' but got '%s'", expected, compact)
+	}
+}
+
+func TestParseFilesCollectsDocumentsAndErrors(t *testing.T) {
+	dir := t.TempDir()
+	var filenames []string
+	for _, content := range []string{"<a/>", "<b/>", "<broken", "<c/>"} {
+		filename := filepath.Join(dir, fmt.Sprintf("%d.xml", len(filenames)))
+		_ = os.WriteFile(filename, []byte(content), 0644)
+		filenames = append(filenames, filename)
+	}
+
+	docs, err := xmldom.ParseFiles(filenames, 2)
+	if err == nil || !strings.Contains(err.Error(), filenames[2]) {
+		t.Fatalf("Expect an error mentioning %s but got %v", filenames[2], err)
+	}
+	if len(docs) != 3 || docs[filenames[3]].Root.Name != "c" {
+		t.Fatalf("Expect the 3 valid documents but got %v", docs)
+	}
+}
+
+func TestParserWithRetainRawText(t *testing.T) {
+	doc := xmldom.Must(xmldom.NewDOMParser().RetainRawText(true).ParseXML(`<foo> bar </foo>`))
+
+	if doc.Root.Text != "bar" || doc.Root.RawText() != " bar " {
+		t.Fatalf("Expect text 'bar' and raw text ' bar ' but got '%s' and '%s'", doc.Root.Text, doc.Root.RawText())
+	}
+
+	doc.Root.SetText("baz")
+	if doc.Root.RawText() != "baz" {
+		t.Fatalf("Expect the raw text to follow SetText but got '%s'", doc.Root.RawText())
+	}
+	doc.Root.SetUnsafeRawText("<b/>")
+	if doc.Root.RawText() != "<b/>" {
+		t.Fatalf("Expect the raw text to follow SetUnsafeRawText but got '%s'", doc.Root.RawText())
+	}
+}
+
+func TestQueryPredicates(t *testing.T) {
+	root := xmldom.Must(xmldom.ParseXML(`<list><item id="1">foo</item><item id="2">bar</item><item id="3">baz</item></list>`)).Root
+
+	for expr, id := range map[string]string{
+		"//item[position()=2]": "2",
+		"//item[last()]":       "3",
+		"//item[text()='foo']": "1",
+		"//item[.='baz']":      "3",
+	} {
+		nodes, err := root.QueryChecked(expr)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", expr, err)
+		}
+		if len(nodes) != 1 || nodes[0].GetAttributeValue("id") != id {
+			t.Fatalf("Expect %s to match item %s but got %v", expr, id, nodes)
+		}
+	}
+
+	if _, err := root.QueryChecked("//item[unknown()]"); err == nil {
+		t.Fatalf("Expect an error for an unsupported function")
+	}
+
+	// positions count per parent, unless the predicate applies to the whole selection
+	root = xmldom.Must(xmldom.ParseXML(`<r><item id="1"/><item id="2"/><item id="3"/><x><item id="4"/><item id="5"/></x></r>`)).Root
+	for expr, expected := range map[string]string{
+		"//item[2]":            "2,5",
+		"//item[position()=2]": "2,5",
+		"//item[last()]":       "3,5",
+		"(//item)[2]":          "2",
+		"(//item)[last()]":     "5",
+	} {
+		var ids []string
+		for _, n := range root.Query(expr) {
+			ids = append(ids, n.GetAttributeValue("id"))
+		}
+		if strings.Join(ids, ",") != expected {
+			t.Fatalf("Expect %s to match items %s but got %v", expr, expected, ids)
+		}
+	}
+
+	// the text node follows the child elements among the siblings that positions count
+	root = xmldom.Must(xmldom.ParseXML(`<r><a id="1">t<b id="2"/><c id="3"/></a><a id="4">u</a></r>`)).Root
+	for expr, expected := range map[string]string{
+		"//a/node()[last()]":              "1,4",
+		"//a/node()[1]":                   "2,4",
+		"//a/text()[position()=1]":        "1,4",
+		"//a/text()/preceding-sibling::*": "2,3",
+	} {
+		var ids []string
+		for _, n := range root.Query(expr) {
+			ids = append(ids, n.GetAttributeValue("id"))
+		}
+		if strings.Join(ids, ",") != expected {
+			t.Fatalf("Expect %s to match nodes %s but got %v", expr, expected, ids)
+		}
+	}
+}
+
+func TestParserWithIgnoreWhitespaceText(t *testing.T) {
+	xml := `<root>
+  <a>text<b/>
+  </a>
+  <c xml:space="preserve"><d/>  </c>
+</root>`
+	dp := xmldom.NewDOMParser().PreserveWhitespace(true).IgnoreWhitespaceText(true)
+	root := xmldom.Must(dp.ParseXML(xml)).Root
+
+	if root.Text != "" {
+		t.Fatalf("Expect whitespace text of root to be dropped but got '%s'", root.Text)
+	}
+	if a := root.GetChild("a"); a.Text != "text" {
+		t.Fatalf("Expect text of a to be kept but got '%s'", a.Text)
+	}
+	if c := root.GetChild("c"); c.Text != "  " {
+		t.Fatalf("Expect preserved whitespace text of c but got '%s'", c.Text)
+	}
+}
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/doc.xml": &fstest.MapFile{Data: []byte(`<doc>text</doc>`)},
+	}
+
+	doc, err := xmldom.ParseFS(fsys, "assets/doc.xml")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if doc.Root.Name != "doc" || doc.Root.Text != "text" {
+		t.Fatalf("Expect <doc>text</doc> but got %s", doc.Root.XML())
+	}
+
+	if _, err := xmldom.ParseFS(fsys, "missing.xml"); err == nil {
+		t.Fatalf("Expect an error for a missing file")
+	}
+}
+
+func TestParseStripsByteOrderMark(t *testing.T) {
+	xml := "\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"UTF-8\"?><root><item/></root>"
+
+	doc := xmldom.Must(xmldom.ParseXML(xml))
+	if doc.ProcInst != `<?xml version="1.0" encoding="UTF-8"?>` || doc.Root.Name != "root" {
+		t.Fatalf("Unexpected document: %s", doc.XML())
+	}
+	if b, _ := xmldom.ExtractOriginal(strings.NewReader(xml), doc.Root.FirstChild()); string(b) != "<item/>" {
+		t.Fatalf("Expect offsets relative to the input including the mark but got '%s'", b)
+	}
+
+	if out := xmldom.NewDOMSerializer().WriteBOM(true).Serialize(doc); out != "\xef\xbb\xbf"+doc.XML() {
+		t.Fatalf("Expect serialized document to start with the mark but got '%s'", out)
+	}
+}
+
+func TestParserWithMaxNodesCountsComments(t *testing.T) {
+	xml := "<root>" + strings.Repeat("<!-- flood -->", 5000) + "</root>"
+
+	if _, err := xmldom.NewDOMParser().MaxNodes(1000).ParseXML(xml); err != xmldom.ErrMaxNodes {
+		t.Fatalf("Expect ErrMaxNodes but got %v", err)
+	}
+	if _, err := xmldom.NewDOMParser().MaxNodes(5001).ParseXML(xml); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestDocumentCloneIsIndependent(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<!DOCTYPE root><root a="1"><child>text</child></root>`))
+	clone := doc.Clone()
+
+	clone.Root.SetAttributeValue("a", "2")
+	clone.Root.FirstChild().Text = "changed"
+	clone.Root.CreateNode("added")
+	clone.Directives[0] = "<!DOCTYPE other>"
+
+	if doc.XML() != `<!DOCTYPE root><root a="1"><child>text</child></root>` {
+		t.Fatalf("Expect original to be unchanged but got %s", doc.XML())
+	}
+	if child := clone.Root.FirstChild(); child.Document != clone || child.Parent != clone.Root {
+		t.Fatalf("Expect cloned nodes to point at the cloned document")
+	}
+}
+
+func TestToDocumentCarriesNamespaces(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root xmlns="urn:d" xmlns:a="urn:a"><list xmlns:a="urn:b"><a:item a:id="1"><sub/></a:item></list></root>`))
+	item := doc.Root.QueryOne("//item")
+	frag := item.ToDocument()
+
+	if frag.Root.XML() != `<a:item a:id="1" xmlns:a="urn:b" xmlns="urn:d"><sub /></a:item>` {
+		t.Fatalf("Unexpected fragment %s", frag.Root.XML())
+	}
+	if sub := frag.Root.FirstChild(); sub.Document != frag || sub.Parent != frag.Root || frag.Root.Parent != nil {
+		t.Fatalf("Expect the fragment nodes to point at the new document")
+	}
+	if item.Document != doc || len(item.Attributes) != 1 {
+		t.Fatalf("Expect the original node to be unchanged")
+	}
+}
+
+func TestQueryPath(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root xmlns:x="urn:x"><a id="1"><b>one</b><x:b/></a><a id="2"><c><b>two</b></c></a></root>`))
+	tests := map[string]string{
+		"/":                   "root",
+		"/root":               "root",
+		"/root/a":             "a1 a2",
+		"//b":                 "b x:b b",
+		"//x:b":               "x:b",
+		"/root/a[@id='2']//b": "b",
+		"//a/*":               "b x:b c",
+		"//@id":               "a1 a2",
+		"//b/text()":          "b b",
+		"a[@id=\"1\"]/b":      "b x:b",
+	}
+	for path, expected := range tests {
+		nodes, err := doc.Root.QueryPath(path)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", path, err)
+		}
+		var names []string
+		for _, n := range nodes {
+			names = append(names, n.QualifiedName()+n.GetAttributeValue("id"))
+		}
+		if strings.Join(names, " ") != expected {
+			t.Fatalf("Expect %s to match %s but got %v", path, expected, names)
+		}
+	}
+
+	for _, path := range []string{"", "//", "a/", "//a[1]", "count(//a)", "child::a", "//@id/b", "a[@id='1'"} {
+		if _, err := doc.Root.QueryPath(path); err == nil || !strings.HasPrefix(err.Error(), "xmldom: unsupported path") {
+			t.Fatalf("Expect an error for %q but got %v", path, err)
+		}
+	}
+}
+
+func TestRenameNamespace(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<v:root xmlns:v="urn:v1"><item xmlns="urn:v1" v:id="1"/><other xmlns="urn:o"/></v:root>`))
+	doc.RenameNamespace("urn:v1", "urn:v2")
+
+	if doc.Root.XML() != `<v:root xmlns:v="urn:v2"><item xmlns="urn:v2" v:id="1" /><other xmlns="urn:o" /></v:root>` {
+		t.Fatalf("Unexpected result %s", doc.Root.XML())
+	}
+	item := doc.Root.FirstChild()
+	if doc.Root.NamespaceURI != "urn:v2" || item.NamespaceURI != "urn:v2" || item.GetAttribute("v:id").NamespaceURI != "urn:v2" {
+		t.Fatalf("Expect the elements and attributes to move to the new namespace")
+	}
+	if other := doc.Root.LastChild(); other.NamespaceURI != "urn:o" {
+		t.Fatalf("Expect other namespaces to be unchanged but got %s", other.NamespaceURI)
+	}
+}
+
+func TestTextContentFollowsDocumentOrder(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<p>one<b>two</b>three<?pi x?><i>four<s>five</s></i>six</p>`))
+	if text := doc.Root.TextContent(); text != "onetwothreefourfivesix" {
+		t.Fatalf("Unexpected text content %q", text)
+	}
+	if text := doc.Root.TextContentExcluding("i"); text != "onetwothreesix" {
+		t.Fatalf("Unexpected text content %q", text)
+	}
+
+	doc.Root.SetText("changed")
+	if text := doc.Root.TextContent(); text != "twofourfivechanged" {
+		t.Fatalf("Expect changed text to follow the children but got %q", text)
+	}
+}
+
+func TestFindByAttr(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root class="a"><p class="b"/><p/><p class="*"/><p class="a"/></root>`))
+	tests := map[string]int{"a": 2, "b": 1, "c": 0, "*": 4, `\*`: 1}
+	for value, expected := range tests {
+		if nodes := doc.Root.FindByAttr("class", value); len(nodes) != expected {
+			t.Fatalf("Expect %d elements for %s but got %d", expected, value, len(nodes))
+		}
+	}
+	if nodes := doc.Root.FindByAttr("class", "a"); nodes[0] != doc.Root || nodes[1] != doc.Root.LastChild() {
+		t.Fatalf("Expect the elements in document order")
+	}
+}
+
+func TestAttributeInDefaultNamespace(t *testing.T) {
+	doc := xmldom.Must(xmldom.NewDOMParser().NamespacePrefixes(map[string]string{"urn:d": ""}).
+		ParseXML(`<root xmlns="urn:d" xmlns:d="urn:d" d:a="1" b="2"/>`))
+
+	if v := doc.Root.GetAttributeValue("a"); v != "1" {
+		t.Fatalf("Expect the default namespace attribute by its local name but got %q", v)
+	}
+	if attr := doc.Root.GetAttribute("a"); attr.NamespaceURI != "urn:d" {
+		t.Fatalf("Expect the attribute to keep its namespace but got %q", attr.NamespaceURI)
+	}
+	if doc.Root.XML() != `<root xmlns="urn:d" xmlns:d="urn:d" a="1" b="2" />` {
+		t.Fatalf("Unexpected output %s", doc.Root.XML())
+	}
+}
+
+func TestHoistNamespacesKeepsNamespaces(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root xmlns="urn:r"><plain xmlns="" id="1"/><x:item xmlns:x="urn:r" x:n="2"/></root>`))
+	doc.HoistNamespaces()
+
+	if doc.Root.XML() != `<x:root xmlns:x="urn:r"><plain id="1" /><x:item x:n="2" /></x:root>` {
+		t.Fatalf("Unexpected result %s", doc.Root.XML())
+	}
+	reparsed := xmldom.Must(xmldom.ParseXML(doc.Root.XML()))
+	if plain := reparsed.Root.FirstChild(); plain.NamespaceURI != "" || reparsed.Root.NamespaceURI != "urn:r" {
+		t.Fatalf("Expect the namespaces to survive the round trip")
+	}
+	if attr := reparsed.Root.LastChild().GetAttribute("x:n"); attr == nil || attr.NamespaceURI != "urn:r" {
+		t.Fatalf("Expect the attribute to stay in its namespace")
+	}
+}
+
+func TestElementsSkipsOtherChildren(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root>text<a/><?pi x?><b><c/></b></root>`))
+	elements := doc.Root.Elements()
+	if len(doc.Root.Children) != 3 || len(elements) != 2 || elements[0].Name != "a" || elements[1].Name != "b" {
+		t.Fatalf("Expect the child elements only but got %d", len(elements))
+	}
+	if leaf := elements[0].Elements(); leaf == nil || len(leaf) != 0 {
+		t.Fatalf("Expect an empty slice for a leaf but got %v", leaf)
+	}
+}
+
+func TestParserWithCollectWarnings(t *testing.T) {
+	xml := `<root a="1" a="2">one<!-- note --><child/>two</root>trailing`
+	doc := xmldom.Must(xmldom.NewDOMParser().CollectWarnings(true).ParseXML(xml))
+
+	expected := []string{
+		"duplicate attribute a on <root> at offset 0",
+		"comment at offset 21 ignored",
+		"char data outside the root element at offset 52 ignored",
+	}
+	if strings.Join(doc.Warnings(), "|") != strings.Join(expected, "|") {
+		t.Fatalf("Unexpected warnings:\n got: %q\nwant: %q", doc.Warnings(), expected)
+	}
+
+	if warnings := xmldom.Must(xmldom.ParseXML(xml)).Warnings(); len(warnings) != 0 {
+		t.Fatalf("Expect no warnings without the option but got %q", warnings)
+	}
+}
+
+func TestRemoveNamespace(t *testing.T) {
+	inkscape := "http://www.inkscape.org/namespaces/inkscape"
+	xml := `<svg xmlns:inkscape="` + inkscape + `" inkscape:version="1.0" width="10"><inkscape:grid/><g inkscape:label="layer"><rect/></g></svg>`
+	root := xmldom.Must(xmldom.ParseXML(xml)).Root
+
+	root.RemoveNamespace(inkscape)
+	if out := root.XML(); out != `<svg width="10"><g><rect /></g></svg>` {
+		t.Fatalf("Expect the inkscape namespace to be gone but got %s", out)
+	}
+}
+
+func TestParserWithStrictProlog(t *testing.T) {
+	dp := xmldom.NewDOMParser().StrictProlog(true)
+
+	if _, err := dp.ParseXML("<?xml version=\"1.0\"?>\n<root/>\n"); err != nil {
+		t.Fatalf("Unexpected error for whitespace outside the root: %v", err)
+	}
+	for _, xml := range []string{`stray<root/>`, `<root/>stray`} {
+		if _, err := dp.ParseXML(xml); err == nil {
+			t.Fatalf("Expect an error for char data outside the root in '%s'", xml)
+		}
+	}
+}
+
+func TestInsertBeforeAndAfter(t *testing.T) {
+	root := xmldom.Must(xmldom.ParseXML(`<root><a/><c/></root>`)).Root
+	a, c := root.GetChild("a"), root.GetChild("c")
+
+	if err := root.InsertAfter(&xmldom.Node{Name: "b"}, a); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := root.InsertAfter(&xmldom.Node{Name: "d"}, c); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := root.InsertBefore(c, a); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out := root.XML(); out != `<root><c /><a /><b /><d /></root>` {
+		t.Fatalf("Unexpected children order: %s", out)
+	}
+
+	if err := root.InsertAfter(&xmldom.Node{Name: "e"}, &xmldom.Node{Name: "other"}); err != xmldom.ErrNotChild {
+		t.Fatalf("Expect ErrNotChild but got %v", err)
+	}
+}
+
+func TestParseProcessingInstructionWithinElement(t *testing.T) {
+	xml := `<?xml version="1.0" encoding="UTF-8"?><page><title>t</title><?php echo 1; ?><footer /></page>`
+	doc := xmldom.Must(xmldom.ParseXML(xml))
+
+	if doc.ProcInst != `<?xml version="1.0" encoding="UTF-8"?>` {
+		t.Fatalf("Expect the declaration to be kept but got '%s'", doc.ProcInst)
+	}
+	children := doc.Root.Children
+	if len(children) != 3 || children[1].Type != xmldom.ProcInstNode || children[1].Name != "php" {
+		t.Fatalf("Expect the processing instruction as second child but got %v", children)
+	}
+	if found := doc.Root.FindByName("php"); len(found) != 0 {
+		t.Fatalf("Expect processing instructions not to be found as elements")
+	}
+	if nodes := doc.Root.Query("/*"); len(nodes) != 2 {
+		t.Fatalf("Expect xpath to select the 2 elements only but got %d", len(nodes))
+	}
+	if out := doc.XML(); out != xml {
+		t.Fatalf("Expect the processing instruction to be serialized in place but got %s", out)
+	}
+}
+
+func TestRenameChecked(t *testing.T) {
+	node := xmldom.NewDocument("old").Root
+
+	for _, name := range []string{"new", "svg:rect", "_x", "élément", "a-b.c1"} {
+		if err := node.RenameChecked(name); err != nil || node.Name != name {
+			t.Fatalf("Expect rename to '%s' but got '%s' and %v", name, node.Name, err)
+		}
+	}
+	for _, name := range []string{"", "1a", "-a", "a b", "a<b"} {
+		if err := node.RenameChecked(name); err == nil {
+			t.Fatalf("Expect an error renaming to '%s'", name)
+		}
+	}
+}
+
+func TestParseNamespaceDeclarationAttribute(t *testing.T) {
+	root := xmldom.Must(xmldom.ParseXML(`<root xmlns:foo="urn:foo" foo:bar="1"/>`)).Root
+
+	attr := root.Attributes[0]
+	if attr.Name != "xmlns:foo" || attr.Value != "urn:foo" {
+		t.Fatalf("Expect xmlns:foo=\"urn:foo\" but got %s=\"%s\"", attr.Name, attr.Value)
+	}
+	if attr.NamespaceURI != "http://www.w3.org/2000/xmlns/" {
+		t.Fatalf("Expect the declaration in the xmlns namespace but got '%s'", attr.NamespaceURI)
+	}
+	if root.GetAttributeValue("foo:bar") != "1" {
+		t.Fatalf("Expect attribute foo:bar but got %s", root.XML())
+	}
+}
+
+func TestParserWithMaxBytes(t *testing.T) {
+	xml := `<root>` + strings.Repeat(`<item/>`, 100) + `</root>`
+
+	if _, err := xmldom.NewDOMParser().MaxBytes(100).ParseXML(xml); err != xmldom.ErrMaxBytes {
+		t.Fatalf("Expect ErrMaxBytes but got %v", err)
+	}
+	if _, err := xmldom.NewDOMParser().MaxBytes(int64(len(xml))).ParseXML(xml); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestSerializerWithMinimizeNamespaces(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<feed>` +
+		`<entry xmlns:a="urn:a" a:id="1"/>` +
+		`<entry xmlns:a="urn:a"><link xmlns:a="urn:a" a:href="2"/></entry>` +
+		`<entry xmlns:b="urn:b1"/><entry xmlns:b="urn:b2"><i xmlns:b="urn:b2"/></entry></feed>`))
+
+	expected := `<feed xmlns:a="urn:a">` +
+		`<entry a:id="1" />` +
+		`<entry><link a:href="2" /></entry>` +
+		`<entry xmlns:b="urn:b1" /><entry xmlns:b="urn:b2"><i /></entry></feed>`
+	if xml := xmldom.NewDOMSerializer().MinimizeNamespaces(true).Serialize(doc); xml != expected {
+		t.Fatalf("Unexpected minimized output:\n got: %s\nwant: %s", xml, expected)
+	}
+}
+
+func TestParserWithDefaultNamespace(t *testing.T) {
+	dp := xmldom.NewDOMParser().DefaultNamespace("urn:feed")
+	root := xmldom.Must(dp.ParseXML(`<entry id="1"><title/><x:link xmlns:x="urn:x"/><raw xmlns=""/></entry>`)).Root
+
+	if root.NamespaceURI != "urn:feed" || root.GetChild("title").NamespaceURI != "urn:feed" {
+		t.Fatalf("Expect unprefixed elements in the default namespace but got '%s'", root.NamespaceURI)
+	}
+	if uri := root.GetChild("link").NamespaceURI; uri != "urn:x" {
+		t.Fatalf("Expect the prefixed element in its own namespace but got '%s'", uri)
+	}
+	if uri := root.GetChild("raw").NamespaceURI; uri != "" {
+		t.Fatalf("Expect xmlns=\"\" to override the default namespace but got '%s'", uri)
+	}
+	if attr := root.GetAttribute("id"); attr.NamespaceURI != "" {
+		t.Fatalf("Expect the attribute in no namespace but got '%s'", attr.NamespaceURI)
+	}
+}
+
+func TestDocumentWriteTo(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<?xml version="1.0"?><root><a>text</a></root>`))
+
+	var _ io.WriterTo = doc
+	buf := new(bytes.Buffer)
+	n, err := doc.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.String() != doc.XML() || n != int64(buf.Len()) {
+		t.Fatalf("Expect %d bytes of %s but got %d bytes of %s", len(doc.XML()), doc.XML(), n, buf.String())
+	}
+}
+
+func FuzzParseSafe(f *testing.F) {
+	for _, seed := range []string{
+		`<?xml version="1.0"?><root a="1"><!--c--><b xml:space="preserve"> x </b><?pi x?></root>`,
+		`<x:a xmlns:x="urn:x" x:b="1"><![CDATA[<c>]]></x:a>`,
+		`<root><a>`,
+		"\xef\xbb\xbf<root/>",
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := xmldom.ParseSafe(bytes.NewReader(data))
+		if err == nil && doc == nil {
+			t.Fatalf("Expect a document or an error for %q", data)
+		}
+	})
+}
+
+func TestDeclareNamespace(t *testing.T) {
+	root := xmldom.NewDocument("feed").Root.
+		DeclareNamespace("", "http://www.w3.org/2005/Atom").
+		DeclareNamespace("media", "urn:old").
+		DeclareNamespace("media", "http://search.yahoo.com/mrss/")
+	if xml := root.XML(); xml != `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/" />` {
+		t.Fatalf("Unexpected declarations: %s", xml)
+	}
+
+	for _, prefix := range []string{"xmlns", "a:b", "1a", "xml"} {
+		if err := root.DeclareNamespaceChecked(prefix, "urn:x"); err == nil {
+			t.Fatalf("Expect an error for the prefix '%s'", prefix)
+		}
+	}
+	if err := root.DeclareNamespaceChecked("xml", "http://www.w3.org/XML/1998/namespace"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := root.DeclareNamespaceChecked("m", "urn:m"); err != nil || root.GetAttributeValue("xmlns:m") != "urn:m" {
+		t.Fatalf("Expect the checked declaration but got %v, %s", err, root.XML())
+	}
+}
+
+func TestEqualIgnoringPrefixes(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<a:root xmlns:a="urn:a" a:id="1" n="2"><a:item>x</a:item></a:root>`))
+
+	same := xmldom.Must(xmldom.ParseXML(`<root xmlns="urn:a" xmlns:b="urn:a" n="2" b:id="1"><b:item>x</b:item></root>`))
+	if !doc.EqualIgnoringPrefixes(same) || !same.EqualIgnoringPrefixes(doc) {
+		t.Fatalf("Expect %s and %s to be equal", doc.XML(), same.XML())
+	}
+	for _, xml := range []string{
+		`<root xmlns="urn:b" xmlns:b="urn:a" n="2" b:id="1"><b:item>x</b:item></root>`,
+		`<root xmlns="urn:a" n="2" id="1"><item>x</item></root>`,
+		`<root xmlns="urn:a" xmlns:b="urn:a" n="2" b:id="1"><b:item>y</b:item></root>`,
+		`<root xmlns="urn:a" xmlns:b="urn:a" n="2" b:id="1" c="3"><b:item>x</b:item></root>`,
+	} {
+		if doc.EqualIgnoringPrefixes(xmldom.Must(xmldom.ParseXML(xml))) {
+			t.Fatalf("Expect %s and %s to differ", doc.XML(), xml)
+		}
+	}
+}
+
+func TestParserWithLazyDepth(t *testing.T) {
+	xml := `<feed xmlns:m="urn:m" xml:space="preserve"><entry id="1">text<m:link m:href="a">  </m:link><?pi?></entry><entry id="2"/></feed>`
+	dp := xmldom.NewDOMParser().LazyDepth(2).IgnoreWhitespaceText(true)
+	doc := xmldom.Must(dp.ParseXML(xml))
+
+	entry := doc.Root.GetChild("entry")
+	if len(entry.Children) != 0 || entry.Text != "text" {
+		t.Fatalf("Expect the text but no children of the deferred entry, got %d children and '%s'", len(entry.Children), entry.Text)
+	}
+	link := entry.FirstChild()
+	if link == nil || link.Parent != entry || link.Document != doc {
+		t.Fatalf("Expect the deferred link to be materialized into the tree, got %v", link)
+	}
+	if link.NamespaceURI != "urn:m" || link.GetAttributeValue("m:href") != "a" || link.Text != "  " {
+		t.Fatalf("Expect the namespace and xml:space scope of the link, got %s", link.XML())
+	}
+	if original, _ := xmldom.ExtractOriginal(strings.NewReader(xml), link); string(original) != `<m:link m:href="a">  </m:link>` {
+		t.Fatalf("Expect the offsets of the link to point into the document, got '%s'", original)
+	}
+	if out := doc.XML(); out != xmldom.Must(xmldom.NewDOMParser().IgnoreWhitespaceText(true).ParseXML(xml)).XML() {
+		t.Fatalf("Expect the same output as parsed eagerly, got %s", out)
+	}
+	if err := doc.Err(); err != nil {
+		t.Fatalf("Unexpected error materializing the children: %v", err)
+	}
+
+	// malformed deferred content fails the parse up front
+	for _, malformed := range []string{
+		`<feed><entry><a></b></entry></feed>`,
+		`<feed><entry><a>&bogus;</a></entry></feed>`,
+		`<feed><entry><a>]]></a></entry></feed>`,
+	} {
+		if _, err := dp.ParseXML(malformed); err == nil {
+			t.Fatalf("Expect an error parsing %s", malformed)
+		}
+	}
+}
+
+func TestLazyDepthCollectsWarningsConcurrently(t *testing.T) {
+	var xml strings.Builder
+	xml.WriteString("<feed>")
+	for i := 0; i < 8; i++ {
+		xml.WriteString("<entry><!-- note --><title/></entry>")
+	}
+	xml.WriteString("</feed>")
+	doc := xmldom.Must(xmldom.NewDOMParser().LazyDepth(2).CollectWarnings(true).ParseXML(xml.String()))
+
+	var wg sync.WaitGroup
+	for _, entry := range doc.Root.Children {
+		wg.Add(1)
+		go func(entry *xmldom.Node) {
+			defer wg.Done()
+			entry.FirstChild()
+			doc.Warnings()
+		}(entry)
+	}
+	wg.Wait()
+	if warnings := doc.Warnings(); len(warnings) != 8 {
+		t.Fatalf("Expect a warning for each deferred comment but got %v", warnings)
+	}
+}
+
+func TestQueryUnionInDocumentOrder(t *testing.T) {
+	root := xmldom.Must(xmldom.ParseXML(`<doc><b id="1"/><a id="2"><b id="3"/></a><c id="4"/><a id="5"/></doc>`)).Root
+
+	for i := 0; i < 10; i++ {
+		var ids []string
+		for _, n := range root.Query("//c | //a | //b | //a[@id='5'] | //*[@id='1']") {
+			ids = append(ids, n.GetAttributeValue("id"))
+		}
+		if strings.Join(ids, ",") != "1,2,3,4,5" {
+			t.Fatalf("Expect the union in document order without duplicates but got %v", ids)
+		}
+	}
+}
+
+func TestCleanWhitespace(t *testing.T) {
+	dp := xmldom.NewDOMParser().PreserveWhitespace(true)
+	doc := xmldom.Must(dp.ParseXML("<root>\n  <a> x </a>\n  <pre xml:space=\"preserve\">\n  <b> </b></pre>\n  <c xml:space=\"preserve\"><d xml:space=\"default\"> </d></c>\n</root>"))
+
+	doc.CleanWhitespace()
+	if xml := doc.Root.XML(); xml != "<root><a> x </a><pre xml:space=\"preserve\">&#xA;  <b> </b></pre><c xml:space=\"preserve\"><d xml:space=\"default\" /></c></root>" {
+		t.Fatalf("Unexpected cleaned up document: %q", xml)
+	}
+}
+
+func TestParserWithLenient(t *testing.T) {
+	xml := `<feed><entry id=1 hidden>caf&eacute;<br></entry></feed>`
+	if _, err := xmldom.ParseXML(xml); err == nil {
+		t.Fatalf("Expect an error parsing malformed XML strictly")
+	}
+
+	doc, err := xmldom.NewDOMParser().Lenient(true).ParseXML(xml)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entry := doc.Root.GetChild("entry")
+	if entry == nil || entry.GetAttributeValue("id") != "1" || entry.GetChild("br") == nil {
+		t.Fatalf("Expect a best effort DOM but got %s", doc.XML())
+	}
+}
+
+func TestParserWithLenientAndLazyDepth(t *testing.T) {
+	tests := []struct {
+		xml   string
+		depth int
+	}{
+		{`<r><a><b>x</a></r>`, 3},
+		{`<r><a><b>x</r>`, 2},
+		{`<r><a><br></a></r>`, 3},
+	}
+	for _, test := range tests {
+		eager := xmldom.Must(xmldom.NewDOMParser().Lenient(true).ParseXML(test.xml))
+		doc, err := xmldom.NewDOMParser().Lenient(true).LazyDepth(test.depth).ParseXML(test.xml)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", test.xml, err)
+		}
+		if doc.XML() != eager.XML() {
+			t.Fatalf("Expect %s to parse as %s at depth %d, got %s", test.xml, eager.XML(), test.depth, doc.XML())
+		}
+	}
+}
+
+func TestOwnerDocumentAndRoot(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<root><a><b/></a></root>`))
+	a := doc.Root.GetChild("a")
+	b := a.GetChild("b")
+	if b.Root() != doc.Root || b.OwnerDocument() != doc {
+		t.Fatalf("Expect the document root and document of a node in the tree")
+	}
+
+	detached := &xmldom.Node{Name: "top"}
+	detached.AppendChild(&xmldom.Node{Name: "leaf"})
+	leaf := detached.FirstChild()
+	leaf.Document = nil
+	if leaf.Root() != detached || leaf.OwnerDocument() != nil {
+		t.Fatalf("Expect the top of a detached subtree without document")
+	}
+	detached.Document = doc
+	if leaf.OwnerDocument() != doc {
+		t.Fatalf("Expect the document of the topmost ancestor for a nil Document pointer")
+	}
+}
+
+func TestPrologOrderRoundTrip(t *testing.T) {
+	prolog := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">` +
+		`<?xml-stylesheet type="text/css" href="style.css"?>`
+	xml := prolog + `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Page</title></head><body /></html>`
+	doc := xmldom.Must(xmldom.ParseXML(xml))
+
+	if len(doc.Prolog) != 2 || doc.Prolog[0].Type != xmldom.DirectiveNode || doc.Prolog[1].Name != "xml-stylesheet" {
+		t.Fatalf("Expect the doctype and the stylesheet in the prolog but got %v", doc.Prolog)
+	}
+	if out := doc.XML(); out != xml {
+		t.Fatalf("Expect the prolog order to be kept:\n got: %s\nwant: %s", out, xml)
+	}
+	if out := doc.Clone().XML(); out != xml {
+		t.Fatalf("Expect the clone to keep the prolog but got %s", out)
+	}
+}
+
+func TestEpilogRoundTrip(t *testing.T) {
+	xml := `<?xml version="1.0"?><root /><?pi x?><?other y?>`
+	doc := xmldom.Must(xmldom.ParseXML(xml))
+
+	if doc.ProcInst != `<?xml version="1.0"?>` || len(doc.Prolog) != 0 {
+		t.Fatalf("Expect the declaration to be kept but got %s", doc.ProcInst)
+	}
+	if len(doc.Epilog) != 2 || doc.Epilog[0].Name != "pi" || doc.Epilog[1].Text != "y" {
+		t.Fatalf("Expect the instructions after the root in the epilog but got %v", doc.Epilog)
+	}
+	if out := doc.XML(); out != xml {
+		t.Fatalf("Expect the epilog after the root:\n got: %s\nwant: %s", out, xml)
+	}
+	if out := doc.Clone().XML(); out != xml {
+		t.Fatalf("Expect the clone to keep the epilog but got %s", out)
+	}
+	if out := doc.XMLPretty(); out != "<?xml version=\"1.0\"?>\n<root />\n<?pi x?>\n<?other y?>\n" {
+		t.Fatalf("Unexpected pretty output %q", out)
+	}
+}
+
+func TestParserJoinsCharData(t *testing.T) {
+	xml := `<p>Hello <b>bold</b> world<!-- note -->!</p>`
+	doc := xmldom.Must(xmldom.ParseXML(xml))
+	if text := doc.Root.Text; text != "Hello  world!" {
+		t.Fatalf("Expect the chunks to be concatenated but got '%s'", text)
+	}
+	for _, out := range []string{doc.Root.XML(), doc.XMLCompact(), doc.XMLPretty()} {
+		if !strings.Contains(out, `<p>Hello <b>bold</b> world!</p>`) {
+			t.Fatalf("Expect the text written in document order but got %q", out)
+		}
+	}
+	if out := doc.Root.SetText("new").XML(); out != `<p><b>bold</b>new</p>` {
+		t.Fatalf("Expect changed text after the children but got %s", out)
+	}
+
+	dp := xmldom.NewDOMParser().CharDataJoiner(func(existing, next string) string {
+		return existing + "|" + next
+	})
+	doc = xmldom.Must(dp.ParseXML(xml))
+	if text := doc.Root.Text; text != "Hello | world|!" {
+		t.Fatalf("Expect the chunks to be joined by the joiner but got '%s'", text)
+	}
+	if out := doc.Root.XML(); out != `<p>Hello <b>bold</b>| world|!</p>` {
+		t.Fatalf("Expect the joined text written in document order but got %s", out)
+	}
+}
+
+func TestQueryDepthExtension(t *testing.T) {
+	root := xmldom.Must(xmldom.ParseXML(`<a id="1"><b id="2"><c id="3" depth="x"/></b><b id="4"/></a>`)).Root
+
+	for expr, expected := range map[string]string{
+		"self::node()[depth()=1]":          "1",
+		"//*[depth() = 2]":                 "2,4",
+		"//*[depth()>2]":                   "3",
+		"//*[@depth='x' and depth()=3]":    "3",
+		"//*[@id=concat('de','pth()')]":    "",
+		"//b[depth()=2][not(depth ( )=3)]": "2,4",
+	} {
+		nodes, err := root.QueryChecked(expr)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", expr, err)
+		}
+		var ids []string
+		for _, n := range nodes {
+			ids = append(ids, n.GetAttributeValue("id"))
+		}
+		if strings.Join(ids, ",") != expected {
+			t.Fatalf("Expect %s to match %s but got %v", expr, expected, ids)
+		}
+	}
+}
+
+func TestParserWithPreserveOriginalPrefixes(t *testing.T) {
+	xml := `<svg xmlns:xl="http://www.w3.org/1999/xlink"><use xl:href="#a"/></svg>`
+
+	use := xmldom.Must(xmldom.ParseXML(xml)).Root.GetChild("use")
+	if use.GetAttribute("xlink:href") == nil {
+		t.Fatalf("Expect the canonical xlink prefix by default but got %s", use.XML())
+	}
+	use = xmldom.Must(xmldom.NewDOMParser().PreserveOriginalPrefixes(true).ParseXML(xml)).Root.GetChild("use")
+	if attr := use.GetAttribute("xl:href"); attr == nil || attr.NamespaceURI != "http://www.w3.org/1999/xlink" {
+		t.Fatalf("Expect the original xl prefix but got %s", use.XML())
+	}
+}
+
+func TestParseFileRecordsSource(t *testing.T) {
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.xml"), filepath.Join(dir, "bad.xml")
+	_ = os.WriteFile(good, []byte("<a/>"), 0644)
+	_ = os.WriteFile(bad, []byte("<a>"), 0644)
+
+	if doc, err := xmldom.ParseFile(good); err != nil || doc.Source != good {
+		t.Fatalf("Expect the source to be %s but got %v", good, err)
+	}
+	_, err := xmldom.ParseFile(bad)
+	if err == nil || !strings.HasPrefix(err.Error(), bad+": ") {
+		t.Fatalf("Expect the error to mention %s but got %v", bad, err)
+	}
+	if _, err := xmldom.ParseFiles([]string{bad}, 1); strings.Count(err.Error(), bad) != 1 {
+		t.Fatalf("Expect the error to mention %s once but got %v", bad, err)
+	}
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	for _, xml := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xl="http://www.w3.org/1999/xlink"><use xl:href="#a" xml:lang="en"/></svg>`,
+		`<a:root xmlns:a="urn:a"><a:item a:id="1"/></a:root>`,
+	} {
+		if err := xmldom.Must(xmldom.ParseXML(xml)).ValidateNamespaces(); err != nil {
+			t.Fatalf("Unexpected error for %s: %v", xml, err)
+		}
+	}
+
+	for xml, expected := range map[string]string{
+		`<root><a:item/></root>`:                        `xmldom: undeclared namespace prefix "a" on <item> at offset 6`,
+		`<root xmlns:a="urn:a"><item b:id="1"/></root>`: `xmldom: undeclared namespace prefix "b" of attribute b:id on <item> at offset 22`,
+	} {
+		if err := xmldom.Must(xmldom.ParseXML(xml)).ValidateNamespaces(); err == nil || err.Error() != expected {
+			t.Fatalf("Expect '%s' for %s but got %v", expected, xml, err)
+		}
+	}
+
+	doc := xmldom.NewDocument("root")
+	doc.Root.CreateNode("svg:rect")
+	if err := doc.ValidateNamespaces(); err == nil || !strings.Contains(err.Error(), `prefix "svg"`) {
+		t.Fatalf("Expect an error for the undeclared svg prefix but got %v", err)
+	}
+	doc.Root.DeclareNamespace("svg", "http://www.w3.org/2000/svg")
+	if err := doc.ValidateNamespaces(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateIDs(t *testing.T) {
+	doc := xmldom.Must(xmldom.ParseXML(`<doc><sec id="a" xml:id="a"><p xml:id="b"/></sec><sec id="b"/><p ref="a" key="c"/><p key="c"/></doc>`))
+
+	err := doc.ValidateIDs()
+	if err == nil || err.Error() != `xmldom: duplicate ID "b" on /doc/sec[1]/p, /doc/sec[2]` {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = doc.ValidateIDs("key")
+	if err == nil || err.Error() != `xmldom: duplicate ID "c" on /doc/p[1], /doc/p[2]` {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := doc.ValidateIDs("ref"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestParserRetainsElementPrefixes(t *testing.T) {
+	xml := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:s="http://www.w3.org/2000/svg" xmlns:i="urn:i">` +
+		`<s:rect i:x="1"/><i:layer><g xmlns="urn:g"><i:grid/></g></i:layer><u:raw/></svg>`
+	doc := xmldom.Must(xmldom.ParseXML(xml))
+
+	var names []string
+	doc.Root.Walk(func(n *xmldom.Node) {
+		names = append(names, n.QualifiedName())
+	})
+	if strings.Join(names, " ") != "svg rect i:layer g i:grid u:raw" {
+		t.Fatalf("Unexpected qualified names: %v", names)
+	}
+	if layer := doc.Root.GetChild("layer"); layer.Name != "layer" || layer.NamespaceURI != "urn:i" {
+		t.Fatalf("Expect the local name and namespace of the layer but got %s in %s", layer.Name, layer.NamespaceURI)
+	}
+	reparsed := xmldom.Must(xmldom.ParseXML(doc.XML()))
+	if !doc.EqualIgnoringPrefixes(reparsed) || !strings.Contains(doc.XML(), "<i:layer><g xmlns=\"urn:g\"><i:grid /></g></i:layer>") {
+		t.Fatalf("Expect the prefixes to be serialized but got %s", doc.XML())
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	dp := xmldom.NewDOMParser()
+	doc := new(xmldom.Document)
+	for _, xml := range []string{`<?xml version="1.0"?><feed/>`, `<entry id="1"><title>a</title></entry>`, `<entry id="2"/>`} {
+		if err := dp.ParseInto(doc, strings.NewReader(xml)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := dp.ParseInto(doc, strings.NewReader(`<broken`)); err == nil {
+		t.Fatalf("Expect an error for broken XML")
+	}
+
+	if xml := doc.XML(); xml != `<?xml version="1.0"?><feed><entry id="1"><title>a</title></entry><entry id="2" /></feed>` {
+		t.Fatalf("Unexpected composite document: %s", xml)
+	}
+	title := doc.Root.FindOneByName("title")
+	if title.Document != doc || title.Parent.Parent != doc.Root || doc.Root.FirstChild().Document != doc {
+		t.Fatalf("Expect the parsed nodes to belong to the document")
+	}
+}
+
+func TestMinimalEscapeRoundTrips(t *testing.T) {
+	doc := xmldom.NewDocument("a")
+	doc.Root.SetText("x]]>y > z")
+	xml := xmldom.NewDOMSerializer().EscapePolicy(xmldom.MinimalEscape).Serialize(doc)
+	if !strings.Contains(xml, "<a>x]]&gt;y > z</a>") {
+		t.Fatalf("Expect only the > of ]]> escaped but got %s", xml)
+	}
+	if reparsed, err := xmldom.ParseXML(xml); err != nil || reparsed.Root.Text != "x]]>y > z" {
+		t.Fatalf("Expect the text to round trip but got %v", err)
+	}
 }
@@ -1,9 +1,11 @@
 package xmldom_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
-	"github.com/subchen/go-xmldom"
+	"github.com/rtenhove/go-xmldom"
 )
 
 const (
@@ -154,3 +156,409 @@ func ExampleNewDocument() {
 	//   </testsuite>
 	// </testsuites>
 }
+
+func ExampleDocument_Stats() {
+	doc := xmldom.Must(xmldom.ParseXML(ExampleXml))
+	fmt.Printf("%+v\n", doc.Stats())
+	// Output:
+	// {Nodes:8 Elements:7 Attributes:13 MaxDepth:4}
+}
+
+func ExampleNode_SortChildrenByName() {
+	root := xmldom.Must(xmldom.ParseXML(`<config><port>80</port><host>a</host><debug/><host>b</host></config>`)).Root
+	fmt.Println(root.SortChildrenByName().XML())
+	// Output:
+	// <config><debug /><host>a</host><host>b</host><port>80</port></config>
+}
+
+func ExampleNode_GetAttributeInt() {
+	node := xmldom.Must(xmldom.ParseXML(`<rect x="10" width="2.5" hidden="false"/>`)).Root
+	x, _ := node.GetAttributeInt("x")
+	width, _ := node.GetAttributeFloat("width")
+	hidden, _ := node.GetAttributeBool("hidden")
+	_, err := node.GetAttributeInt("width")
+	fmt.Println(x, width, hidden, node.GetAttributeIntDefault("y", -1), err != nil)
+	// Output:
+	// 10 2.5 false -1 true
+}
+
+func ExampleDOMSerializer_OmitDeclaration() {
+	doc := xmldom.Must(xmldom.ParseXML(`<?xml version="1.0" encoding="UTF-8"?><fragment><item /></fragment>`))
+	fmt.Println(xmldom.NewDOMSerializer().OmitDeclaration(true).Serialize(doc))
+	// Output:
+	// <fragment><item /></fragment>
+}
+
+func ExampleDocument_RootNamed() {
+	doc := xmldom.Must(xmldom.ParseXML(`<html><body /></html>`))
+	_, err := doc.RootNamed("svg")
+	fmt.Println(err)
+	// Output:
+	// xmldom: expected root <svg>, got <html>
+}
+
+func ExampleNode_SetText() {
+	doc := xmldom.NewDocument("formula")
+	doc.Root.SetText("a < b & c")
+	fmt.Println(doc.Root.XML())
+	// Output:
+	// <formula>a &lt; b &amp; c</formula>
+}
+
+func ExampleNode_FindByLocalName() {
+	doc := xmldom.NewDocument("svg")
+	doc.Root.CreateNode("rect")
+	doc.Root.CreateNode("svg:rect")
+	fmt.Println(len(doc.Root.FindByName("rect")), len(doc.Root.FindByLocalName("rect")))
+	// Output:
+	// 1 2
+}
+
+func ExampleDocument_UnmarshalText() {
+	var config struct {
+		Name string
+		Body *xmldom.Document
+	}
+	_ = json.Unmarshal([]byte(`{"Name":"example","Body":"<body><item>1</item></body>"}`), &config)
+	fmt.Println(config.Body.Root.FirstChild().Text)
+
+	config.Body.Root.FirstChild().Text = "2"
+	text, _ := config.Body.MarshalText()
+	fmt.Println(string(text))
+	// Output:
+	// 1
+	// <body><item>2</item></body>
+}
+
+func ExampleDOMSerializer_EscapePolicy() {
+	doc := xmldom.NewDocument("p")
+	doc.ProcInst = ""
+	doc.Root.SetAttributeValue("title", `"1 > 0"`).SetText("café & 'bar'")
+
+	for _, policy := range []xmldom.EscapePolicy{xmldom.MinimalEscape, xmldom.StandardEscape, xmldom.AggressiveEscape} {
+		fmt.Println(xmldom.NewDOMSerializer().EscapePolicy(policy).Serialize(doc))
+	}
+	// Output:
+	// <p title="&#34;1 > 0&#34;">café &amp; 'bar'</p>
+	// <p title="&#34;1 &gt; 0&#34;">café &amp; &#39;bar&#39;</p>
+	// <p title="&#34;1 &gt; 0&#34;">caf&#xE9; &amp; &#39;bar&#39;</p>
+}
+
+func ExampleNode_SetAttributesOrdered() {
+	doc := xmldom.NewDocument("rect")
+	doc.Root.SetAttributeValue("y", "0")
+	doc.Root.SetAttributesOrdered([][2]string{{"x", "1"}, {"y", "2"}, {"width", "3"}})
+	fmt.Println(doc.Root.XML())
+	// Output:
+	// <rect y="2" x="1" width="3" />
+}
+
+func ExampleLowestCommonAncestor() {
+	root := xmldom.Must(xmldom.ParseXML(ExampleXml)).Root
+	a := root.FindByID("ExampleParseXML")
+	b := root.FindOneByName("property")
+	fmt.Println(xmldom.LowestCommonAncestor(a, b).Name)
+	fmt.Println(xmldom.LowestCommonAncestor(a, a.Parent).Name)
+	// Output:
+	// testsuite
+	// testsuite
+}
+
+func ExampleSerializeNodes() {
+	root := xmldom.Must(xmldom.ParseXML(ExampleXml)).Root
+	fmt.Println(xmldom.SerializeNodes(root.FindByName("testcase")[:2]))
+	// Output:
+	// <testcase classname="go-xmldom" id="ExampleParseXML" time="0.004" /><testcase classname="go-xmldom" id="ExampleParse" time="0.005" />
+}
+
+func ExampleNode_HasDescendant() {
+	root := xmldom.Must(xmldom.ParseXML(ExampleXml)).Root
+	fmt.Println(root.HasChild("testsuite"), root.HasChild("testcase"), root.HasDescendant("testcase"))
+	// Output:
+	// true false true
+}
+
+func ExampleDOMSerializer_TrailingNewline() {
+	doc := xmldom.NewDocument("empty")
+	fmt.Printf("%q\n", xmldom.NewDOMSerializer().TrailingNewline(true).Serialize(doc))
+	// Output:
+	// "<?xml version=\"1.0\" encoding=\"UTF-8\"?><empty />\n"
+}
+
+func ExampleNode_ChildText() {
+	node := xmldom.Must(xmldom.ParseXML(`<book><title>Go</title></book>`)).Root
+	title, ok := node.ChildText("title")
+	fmt.Println(title, ok)
+	author, ok := node.ChildText("author")
+	fmt.Printf("%q %v\n", author, ok)
+	// Output:
+	// Go true
+	// "" false
+}
+
+func ExampleDocument_ElementNames() {
+	doc := xmldom.Must(xmldom.ParseXML(`<shelf id="1"><book lang="en"/><book id="2"><title>Go</title></book></shelf>`))
+	fmt.Println(doc.ElementNames())
+	fmt.Println(doc.AttributeNames())
+	// Output:
+	// [book shelf title]
+	// [id lang]
+}
+
+func ExampleDOMSerializer_MaxIndentDepth() {
+	doc := xmldom.Must(xmldom.ParseXML(`<catalog><book><title>Go</title><author>Pike</author></book></catalog>`))
+	fmt.Print(xmldom.NewDOMSerializer().Indent("  ").MaxIndentDepth(1).Serialize(doc))
+	// Output:
+	// <catalog>
+	//   <book><title>Go</title><author>Pike</author></book>
+	// </catalog>
+}
+
+func ExampleNode_GetAttributeValueDefault() {
+	node := xmldom.Must(xmldom.ParseXML(`<img alt=""/>`)).Root
+	fmt.Printf("%q %q\n", node.GetAttributeValueDefault("alt", "image"), node.GetAttributeValueDefault("align", "left"))
+	// Output:
+	// "" "left"
+}
+
+func ExampleDocument_ToMap() {
+	doc := xmldom.Must(xmldom.ParseXML(`<order id="7"><item sku="a">2</item><item sku="b">1</item><note>rush</note></order>`))
+	m := doc.ToMap()
+	order := m["order"].(map[string]interface{})
+	fmt.Println(order["@id"], order["note"])
+	for _, item := range order["item"].([]interface{}) {
+		item := item.(map[string]interface{})
+		fmt.Println(item["@sku"], item["#text"])
+	}
+	// Output:
+	// 7 rush
+	// a 2
+	// b 1
+}
+
+func ExampleNode_SetUnsafeRawText() {
+	doc := xmldom.NewDocument("envelope")
+	doc.Root.CreateNode("body").SetText("1 < 2")
+	doc.Root.CreateNode("signature").SetUnsafeRawText(`<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"/>`)
+	fmt.Println(doc.Root.XML())
+	// Output:
+	// <envelope><body>1 &lt; 2</body><signature><ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"/></signature></envelope>
+}
+
+func ExampleNode_ChildTexts() {
+	node := xmldom.Must(xmldom.ParseXML(`<post><tag>a</tag><title>T</title><tag>b</tag><meta><tag>c</tag></meta></post>`)).Root
+	fmt.Printf("%q %q\n", node.ChildTexts("tag"), node.ChildTexts("author"))
+	// Output:
+	// ["a" "b"] []
+}
+
+func ExampleNode_Attr() {
+	node := xmldom.Must(xmldom.ParseXML(`<link href="/home" title=""/>`)).Root
+	fmt.Printf("%q %q %v\n", node.Attr("href"), node.Attr("rel"), node.GetAttribute("title") != nil)
+	// Output:
+	// "/home" "" true
+}
+
+func ExampleNode_FindByNames() {
+	root := xmldom.Must(xmldom.ParseXML(`<body><h1>A</h1><p>x</p><section><h2>B</h2><h3>C</h3></section><h2>D</h2></body>`)).Root
+	for _, h := range root.FindByNames("h1", "h2", "h3") {
+		fmt.Print(h.Name, ":", h.Text, " ")
+	}
+	// Output:
+	// h1:A h2:B h3:C h2:D
+}
+
+func ExampleNode_WalkFiltered() {
+	root := xmldom.Must(xmldom.ParseXML(`<doc><meta><title>x</title></meta><body><p>a</p><p>b</p></body></doc>`)).Root
+	root.WalkFiltered(func(n *xmldom.Node) xmldom.FilterResult {
+		switch n.Name {
+		case "meta":
+			return xmldom.FilterReject
+		case "doc", "body":
+			return xmldom.FilterSkip
+		}
+		return xmldom.FilterAccept
+	}, func(n *xmldom.Node) {
+		fmt.Print(n.Name, ":", n.Text, " ")
+	})
+	// Output:
+	// p:a p:b
+}
+
+func ExampleNode_SetAttributeFloat() {
+	node := xmldom.NewDocument("point").Root.
+		SetAttributeInt("id", 7).
+		SetAttributeFloat("x", 0.1).
+		SetAttributeFloat("y", 2.5e-7).
+		SetAttributeBool("visible", true)
+	fmt.Println(node.XML())
+	// Output:
+	// <point id="7" x="0.1" y="2.5e-07" visible="true" />
+}
+
+func ExampleNode_TextNodes() {
+	node := xmldom.Must(xmldom.ParseXML(`<p>Call <b>now</b> or <i>later</i>, please.</p>`)).Root
+	fmt.Printf("%q\n%q\n", node.Text, node.TextNodes())
+	// Output:
+	// "Call  or , please."
+	// ["Call" "or" ", please."]
+}
+
+func ExampleDOMSerializer_AttributeFilter() {
+	doc := xmldom.Must(xmldom.ParseXML(`<order id="1" _rev="3"><item sku="a" _rev="1"/></order>`))
+	fmt.Println(xmldom.NewDOMSerializer().AttributeFilter(func(n *xmldom.Node, a *xmldom.Attribute) bool {
+		return !strings.HasPrefix(a.Name, "_")
+	}).Serialize(doc))
+	fmt.Println(doc.Root.Attr("_rev"))
+	// Output:
+	// <order id="1"><item sku="a" /></order>
+	// 3
+}
+
+func ExampleNode_CountByName() {
+	root := xmldom.Must(xmldom.ParseXML(`<records><record/><batch><record/><record/></batch></records>`)).Root
+	fmt.Println(root.CountByName("record"), root.CountByName("batch"), root.CountByName("missing"))
+	// Output:
+	// 3 1 0
+}
+
+func ExampleDOMSerializer_NewlineStyle() {
+	doc := xmldom.Must(xmldom.ParseXML(`<list><item>a</item></list>`))
+	fmt.Printf("%q\n", xmldom.NewDOMSerializer().Indent("  ").NewlineStyle(xmldom.NewlineCRLF).Serialize(doc))
+	// Output:
+	// "<list>\r\n  <item>a</item>\r\n</list>\r\n"
+}
+
+// subtreeHandler forwards the element and text events within the first <order> element to the
+// tree builder. Documents with CDATA sections or processing instructions need those filtered too.
+type subtreeHandler struct {
+	*xmldom.TreeBuilder
+	depth int
+}
+
+func (h *subtreeHandler) StartElement(uri, name string, attrs []*xmldom.Attribute) error {
+	if h.depth > 0 || name == "order" && h.Document().Root == nil {
+		h.depth++
+		return h.TreeBuilder.StartElement(uri, name, attrs)
+	}
+	return nil
+}
+
+func (h *subtreeHandler) EndElement(uri, name string) error {
+	if h.depth == 0 {
+		return nil
+	}
+	h.depth--
+	return h.TreeBuilder.EndElement(uri, name)
+}
+
+func (h *subtreeHandler) CharData(text string) error {
+	if h.depth == 0 {
+		return nil
+	}
+	return h.TreeBuilder.CharData(text)
+}
+
+func ExampleNewTreeBuilder() {
+	input := `<feed><meta>skipped</meta><order id="1"><item>book</item></order><order id="2"/></feed>`
+	h := &subtreeHandler{TreeBuilder: xmldom.NewTreeBuilder()}
+	if err := xmldom.ParseStream(strings.NewReader(input), h); err != nil {
+		panic(err)
+	}
+	fmt.Println(h.Document().Root.XML())
+	// Output:
+	// <order id="1"><item>book</item></order>
+}
+
+func ExampleDOMSerializer_InlineShortElements() {
+	doc := xmldom.Must(xmldom.ParseXML(`<doc><point><x>1</x><y>2</y></point><point><x>10</x><y>20</y><z>30</z></point></doc>`))
+	fmt.Print(xmldom.NewDOMSerializer().Indent("  ").InlineShortElements(32).Serialize(doc))
+	// Output:
+	// <doc>
+	//   <point><x>1</x><y>2</y></point>
+	//   <point>
+	//     <x>10</x>
+	//     <y>20</y>
+	//     <z>30</z>
+	//   </point>
+	// </doc>
+}
+
+func ExampleNode_TextContentExcluding() {
+	doc := xmldom.Must(xmldom.NewDOMParser().PreserveWhitespace(true).ParseXML(
+		`<body><h1>Title</h1><p>Some <b>bold</b> text.</p><script>track()</script></body>`))
+	fmt.Printf("%q\n", doc.Root.TextContentExcluding("script", "style"))
+	// Output:
+	// "TitleSome bold text."
+}
+
+func ExampleDocument_SetProlog() {
+	doc := xmldom.Must(xmldom.ParseXML(`<?xml version="1.0"?><!DOCTYPE note><note>hi</note>`))
+	doc.SetProlog("<?xml version=\"1.0\" encoding=\"ISO-8859-1\" standalone=\"yes\"?>\n<!-- generated -->\n")
+	fmt.Println(doc.XML())
+	// Output:
+	// <?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?>
+	// <!-- generated -->
+	// <note>hi</note>
+}
+
+func ExampleMapNodes() {
+	doc := xmldom.Must(xmldom.ParseXML(`<path><pt x="1" y="2"/><label/><pt x="3" y="5"/></path>`))
+	type point struct{ x, y int }
+	points := xmldom.MapNodes(doc.Root, func(n *xmldom.Node) (point, bool) {
+		if n.Name != "pt" {
+			return point{}, false
+		}
+		return point{n.GetAttributeIntDefault("x", 0), n.GetAttributeIntDefault("y", 0)}, true
+	})
+	fmt.Println(points)
+	// Output:
+	// [{1 2} {3 5}]
+}
+
+func ExampleNode_WithAttribute() {
+	doc := xmldom.Must(xmldom.ParseXML(`<ul data-id="list"><li data-id="1">one</li><li>two</li><li data-id="">three</li></ul>`))
+	for _, n := range doc.Root.WithAttribute("data-id") {
+		fmt.Printf("%s %q\n", n.Name, n.GetAttributeValue("data-id"))
+	}
+	fmt.Println(len(doc.Root.WithAttribute("class")))
+	// Output:
+	// ul "list"
+	// li "1"
+	// li ""
+	// 0
+}
+
+func ExampleDOMSerializer_WrapAttributes() {
+	doc := xmldom.Must(xmldom.ParseXML(`<svg width="100" height="100"><circle cx="50" cy="50" r="40" stroke="green" fill="yellow"/></svg>`))
+	fmt.Print(xmldom.NewDOMSerializer().Indent("  ").WrapAttributes(40).Serialize(doc))
+	// Output:
+	// <svg width="100" height="100">
+	//   <circle
+	//     cx="50"
+	//     cy="50"
+	//     r="40"
+	//     stroke="green"
+	//     fill="yellow" />
+	// </svg>
+}
+
+func ExampleDocument_HoistNamespaces() {
+	doc := xmldom.Must(xmldom.ParseXML(`<feed xmlns="urn:atom"><entry><a:title xmlns:a="urn:dc">One</a:title></entry>` +
+		`<entry><dc:title xmlns:dc="urn:dc">Two</dc:title><m:size xmlns:m="urn:media" xmlns:a="urn:other" a:unit="px">3</m:size></entry></feed>`))
+	doc.HoistNamespaces()
+	fmt.Println(doc.Root.XML())
+	// Output:
+	// <feed xmlns="urn:atom" xmlns:a="urn:dc" xmlns:m="urn:media" xmlns:ns1="urn:other"><entry><a:title>One</a:title></entry><entry><a:title>Two</a:title><m:size ns1:unit="px">3</m:size></entry></feed>
+}
+
+func ExampleNode_GetAttributeTokens() {
+	doc := xmldom.Must(xmldom.ParseXML(`<rect class=" shape&#9;selected  " />`))
+	fmt.Printf("%q\n", doc.Root.GetAttributeTokens("class"))
+	fmt.Println(doc.Root.HasToken("class", "selected"), doc.Root.HasToken("class", "hidden"))
+	fmt.Println(len(doc.Root.GetAttributeTokens("points")))
+	// Output:
+	// ["shape" "selected"]
+	// true false
+	// 0
+}
@@ -2,4 +2,4 @@ module github.com/rtenhove/go-xmldom
 
 go 1.23.3
 
-require github.com/antchfx/xpath v1.0.0
+require github.com/antchfx/xpath v1.3.8
@@ -0,0 +1,90 @@
+package xmldom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// lazyChildren holds the deferred children of an element parsed with LazyDepth, as the source
+// bytes of the element along with the context needed to parse them on their own.
+type lazyChildren struct {
+	once     sync.Once
+	src      []byte
+	settings *domParserSettings
+	// head is a start tag declaring the namespaces in scope of the element and its xml:space
+	// scope, to wrap the source of the element in
+	head string
+}
+
+// lazyWrapper is the name of the element wrapping the source of a deferred element.
+const lazyWrapper = "_"
+
+func newLazyChildren(n *Node, s *domParserSettings, preserve bool) *lazyChildren {
+	settings := *s
+	settings.lazyDepth = 0
+	settings.maxNodes = 0
+
+	decls := make(map[string]string)
+	for p := n.Parent; p != nil; p = p.Parent {
+		for _, attr := range p.Attributes {
+			if _, ok := decls[attr.Name]; !ok && isNamespaceDecl(attr) {
+				decls[attr.Name] = attr.Value
+			}
+		}
+	}
+	names := make([]string, 0, len(decls))
+	for name := range decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	head := new(bytes.Buffer)
+	head.WriteString("<" + lazyWrapper)
+	for _, name := range names {
+		fmt.Fprintf(head, " %s=\"", name)
+		_ = xml.EscapeText(head, []byte(decls[name]))
+		head.WriteByte('"')
+	}
+	if preserve {
+		head.WriteString(` xml:space="preserve"`)
+	}
+	head.WriteByte('>')
+	return &lazyChildren{settings: &settings, head: head.String()}
+}
+
+// ensureChildren parses the deferred children of the node, if any, on first use.
+func (n *Node) ensureChildren() {
+	if l := n.lazy; l != nil {
+		l.once.Do(func() {
+			l.materialize(n)
+		})
+	}
+}
+
+func (l *lazyChildren) materialize(n *Node) {
+	r := io.MultiReader(strings.NewReader(l.head), bytes.NewReader(l.src), strings.NewReader("</"+lazyWrapper+">"))
+	// the offsets of the wrapped source are shifted back to those of the document
+	b := &domBuilder{settings: l.settings, p: l.settings.newDecoder(r), bom: n.StartOffset - int64(len(l.head))}
+	doc, err := b.build(false)
+	l.src = nil
+	if err != nil {
+		if n.Document != nil {
+			n.Document.setLazyErr(fmt.Errorf("xmldom: cannot parse deferred children of <%s> at offset %d: %w", n.Name, n.StartOffset, err))
+		}
+		return
+	}
+
+	n.Children = doc.Root.Children[0].Children
+	for _, c := range n.Children {
+		c.Parent = n
+		reindex(n.Document, c)
+	}
+	if n.Document != nil {
+		n.Document.addWarnings(doc.warnings)
+	}
+}
@@ -0,0 +1,50 @@
+package xmldom
+
+// ToMap converts the document into native Go values, for dynamic processing without a schema.
+// The map holds a single entry, keyed by the name of the root element. Elements are represented
+// as follows:
+//
+//   - An element without attributes and child elements is its text, a string.
+//   - Any other element is a map[string]interface{}. Attributes are entries keyed by their name
+//     prefixed with '@', holding the value string. Non-empty text is the "#text" entry. Child
+//     elements are entries keyed by their name. When an element has several children of the same
+//     name, the entry holds a []interface{} of them in document order.
+//
+// Processing instructions within elements are left out. A document without root converts to an
+// empty map.
+func (d *Document) ToMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	if d.Root != nil {
+		m[d.Root.Name] = elementValue(d.Root)
+	}
+	return m
+}
+
+func elementValue(n *Node) interface{} {
+	n.ensureChildren()
+	m := make(map[string]interface{})
+	for _, attr := range n.Attributes {
+		m["@"+attr.Name] = attr.Value
+	}
+	for _, c := range n.Children {
+		if c.Type != ElementNode {
+			continue
+		}
+		v := elementValue(c)
+		switch existing := m[c.Name].(type) {
+		case nil:
+			m[c.Name] = v
+		case []interface{}:
+			m[c.Name] = append(existing, v)
+		default:
+			m[c.Name] = []interface{}{existing, v}
+		}
+	}
+	if len(m) == 0 {
+		return n.Text
+	}
+	if n.Text != "" {
+		m["#text"] = n.Text
+	}
+	return m
+}
@@ -0,0 +1,507 @@
+package xmldom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// attributeNamer names the attributes of decoded elements. The decoder resolves attribute
+// prefixes to namespace URIs, so the namer tracks the xmlns declarations in scope to map them
+// back to a prefix.
+type attributeNamer struct {
+	prefixes map[string]string
+	scopes   []map[string]string
+	// original prefers the prefixes declared in the source over the canonical ones
+	original bool
+	// defaults holds the default namespace in scope of each element, on top of defaultNamespace
+	defaults         []string
+	defaultNamespace string
+}
+
+// push converts the decoded attributes of a start element into DOM attributes, after entering
+// the scope of the namespaces the element declares.
+func (an *attributeNamer) push(attrs []xml.Attr) []*Attribute {
+	var scope map[string]string
+	for _, attr := range attrs {
+		if isXmlnsSpace(attr.Name.Space) && !isDefaultNamespaceDecl(attr.Name) {
+			if scope == nil {
+				scope = make(map[string]string)
+			}
+			scope[attr.Value] = attr.Name.Local
+		}
+	}
+	an.scopes = append(an.scopes, scope)
+	def := an.defaultNamespace
+	if len(an.defaults) > 0 {
+		def = an.defaults[len(an.defaults)-1]
+	}
+	for _, attr := range attrs {
+		if isDefaultNamespaceDecl(attr.Name) {
+			def = attr.Value
+		}
+	}
+	an.defaults = append(an.defaults, def)
+
+	var result []*Attribute
+	for _, attr := range attrs {
+		name, ns := attr.Name.Local, attr.Name.Space
+		if isDefaultNamespaceDecl(attr.Name) {
+			name, ns = xmlnsPrefix, xmlnsUrl
+		} else if isXmlnsSpace(ns) {
+			name, ns = fmt.Sprintf("%s:%s", xmlnsPrefix, attr.Name.Local), xmlnsUrl
+		} else if ns != "" {
+			name = an.qualify(ns, def, attr.Name.Local)
+		}
+		result = append(result, &Attribute{
+			Name:         name,
+			Value:        attr.Value,
+			NamespaceURI: ns,
+		})
+	}
+	return result
+}
+
+// isDefaultNamespaceDecl tells if the attribute is a default namespace declaration, which is
+// named exactly xmlns. Depending on the decoder that is reported without a namespace, or as an
+// attribute in the xmlns namespace with either an empty or an xmlns local name.
+func isDefaultNamespaceDecl(name xml.Name) bool {
+	switch name.Space {
+	case "":
+		return name.Local == xmlnsPrefix
+	case xmlnsPrefix, xmlnsUrl, strings.TrimSuffix(xmlnsUrl, "/"):
+		return name.Local == "" || name.Local == xmlnsPrefix
+	}
+	return false
+}
+
+// isXmlnsSpace tells if the attribute namespace is that of the namespace declarations. The decoder
+// leaves it as the xmlns prefix, but it may also be reported as the xmlns namespace URI, which is
+// matched with and without its trailing slash.
+func isXmlnsSpace(space string) bool {
+	return space == xmlnsPrefix || space == xmlnsUrl || space == strings.TrimSuffix(xmlnsUrl, "/")
+}
+
+// isXMLName tells if the given name is a legal XML name, as defined by the Name production of the
+// XML specification.
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isNameStartChar(r) && (i == 0 || !isNameChar(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameStartChar(r rune) bool {
+	return r == ':' || r == '_' ||
+		r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' ||
+		r >= 0xC0 && r <= 0xD6 || r >= 0xD8 && r <= 0xF6 || r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D || r >= 0x37F && r <= 0x1FFF || r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F || r >= 0x2C00 && r <= 0x2FEF || r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF || r >= 0xFDF0 && r <= 0xFFFD || r >= 0x10000 && r <= 0xEFFFF
+}
+
+func isNameChar(r rune) bool {
+	return r == '-' || r == '.' || r >= '0' && r <= '9' || r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F || r >= 0x203F && r <= 0x2040
+}
+
+// localName returns the given name without its prefix.
+func localName(name string) string {
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// isNamespaceDecl tells if the attribute declares a namespace, by its xmlns or xmlns: name.
+func isNamespaceDecl(attr *Attribute) bool {
+	return attr.Name == xmlnsPrefix || strings.HasPrefix(attr.Name, xmlnsPrefix+":")
+}
+
+// pop leaves the namespace scope of the current element.
+func (an *attributeNamer) pop() {
+	if len(an.scopes) > 0 {
+		an.scopes = an.scopes[:len(an.scopes)-1]
+		an.defaults = an.defaults[:len(an.defaults)-1]
+	}
+}
+
+// elementPrefix returns the prefix of an element in the namespace with the given URI, after the
+// element was pushed. The decoder resolved the prefix the source used, which is recovered from
+// the declarations in scope: no prefix in the default namespace, otherwise the prefix bound to the
+// namespace. The decoder leaves an undeclared prefix as the namespace, so that is the prefix then.
+func (an *attributeNamer) elementPrefix(uri string) string {
+	if uri == "" || len(an.defaults) > 0 && uri == an.defaults[len(an.defaults)-1] {
+		return ""
+	}
+	if uri == xmlUrl {
+		return xmlPrefix
+	}
+	if prefix, ok := an.declared(uri); ok {
+		return prefix
+	}
+	return uri
+}
+
+// qualify names an attribute in the given namespace. An attribute in the default namespace def
+// that has no prefix for it, either because none is declared or because NamespacePrefixes maps
+// the namespace to the empty prefix, is named by its local name alone, as a prefix of "" or of
+// the URI itself would make the name unusable.
+func (an *attributeNamer) qualify(ns, def, local string) string {
+	prefix := an.prefix(ns)
+	if prefix == "" || prefix == ns && ns == def {
+		return local
+	}
+	return fmt.Sprintf("%s:%s", prefix, local)
+}
+
+// prefix finds the prefix to use for the given namespace. Namespaces that are not declared keep
+// their name, which the decoder leaves as the undeclared prefix itself.
+func (an *attributeNamer) prefix(uri string) string {
+	if prefix, ok := an.prefixes[uri]; ok {
+		return prefix
+	}
+	if isXmlnsSpace(uri) {
+		return xmlnsPrefix
+	}
+	if an.original && uri != xmlUrl {
+		if prefix, ok := an.declared(uri); ok {
+			return prefix
+		}
+	}
+	switch uri {
+	case xmlUrl:
+		return xmlPrefix
+	case xlinkUrl:
+		return xlinkPrefix
+	case xsiUrl:
+		return xsiPrefix
+	}
+	if prefix, ok := an.declared(uri); ok {
+		return prefix
+	}
+	return uri
+}
+
+// declared returns the prefix bound to the URI by the innermost declaration in scope.
+func (an *attributeNamer) declared(uri string) (string, bool) {
+	for i := len(an.scopes) - 1; i >= 0; i-- {
+		if prefix, ok := an.scopes[i][uri]; ok {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// namespaceMinimizer decides which namespace declarations to write when serializing with
+// minimized namespaces. Prefixed declarations are hoisted to the highest element under which
+// the prefix has a single binding, which is declared there once for all of its descendants.
+type namespaceMinimizer struct {
+	// subtree caches the prefixed declarations made in the subtree of each element, by prefix.
+	subtree map[*Node]map[string]string
+}
+
+// conflictingBinding marks a prefix that is bound to different namespaces within a subtree. It
+// cannot be a namespace URI, as NUL is not an XML character.
+const conflictingBinding = "\x00"
+
+// declarations returns the prefixed declarations made in the subtree of the given node.
+func (m *namespaceMinimizer) declarations(n *Node) map[string]string {
+	n.ensureChildren()
+	if d, ok := m.subtree[n]; ok {
+		return d
+	}
+	d := make(map[string]string)
+	bind := func(prefix, uri string) {
+		if bound, ok := d[prefix]; !ok {
+			d[prefix] = uri
+		} else if bound != uri {
+			d[prefix] = conflictingBinding
+		}
+	}
+	for _, attr := range n.Attributes {
+		if isNamespaceDecl(attr) && attr.Name != xmlnsPrefix {
+			bind(localName(attr.Name), attr.Value)
+		}
+	}
+	for _, c := range n.Children {
+		for prefix, uri := range m.declarations(c) {
+			bind(prefix, uri)
+		}
+	}
+	m.subtree[n] = d
+	return d
+}
+
+// minimize returns the attributes to write for the given element, given the declarations in
+// scope from its ancestors, along with the declarations in scope for its children.
+func (m *namespaceMinimizer) minimize(n *Node, scope map[string]string) ([]*Attribute, map[string]string) {
+	inner, copied := scope, false
+	bind := func(prefix, uri string) {
+		if !copied {
+			inner, copied = make(map[string]string, len(scope)+1), true
+			for p, u := range scope {
+				inner[p] = u
+			}
+		}
+		inner[prefix] = uri
+	}
+
+	attrs := make([]*Attribute, 0, len(n.Attributes))
+	for _, attr := range n.Attributes {
+		if isNamespaceDecl(attr) {
+			prefix := ""
+			if attr.Name != xmlnsPrefix {
+				prefix = localName(attr.Name)
+			}
+			if uri, ok := inner[prefix]; ok && uri == attr.Value {
+				continue
+			}
+			bind(prefix, attr.Value)
+		}
+		attrs = append(attrs, attr)
+	}
+
+	decls := m.declarations(n)
+	prefixes := make([]string, 0, len(decls))
+	for prefix, uri := range decls {
+		if _, ok := inner[prefix]; !ok && uri != conflictingBinding {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		bind(prefix, decls[prefix])
+		attrs = append(attrs, &Attribute{Name: xmlnsPrefix + ":" + prefix, Value: decls[prefix], NamespaceURI: xmlnsUrl})
+	}
+	return attrs, inner
+}
+
+// ValidateNamespaces checks that every prefix used in the names of the elements and attributes
+// is declared by an xmlns attribute in scope, and that every element in a namespace has that
+// namespace declared, as its NamespaceURI is otherwise lost in the output. The parser leaves an
+// undeclared prefix as the namespace of the element. The xml prefix is always declared, as are
+// the canonical xlink and xsi prefixes of attributes in the XLink and XML Schema instance
+// namespaces, which the parser names them by. The error identifies the first offending name in
+// document order, with its element and offset.
+func (d *Document) ValidateNamespaces() error {
+	if d.Root == nil {
+		return nil
+	}
+	return validateNamespaces(d.Root, nil)
+}
+
+func validateNamespaces(n *Node, scope map[string]string) error {
+	if n.Type != ElementNode {
+		return nil
+	}
+	copied := false
+	for _, attr := range n.Attributes {
+		if !isNamespaceDecl(attr) {
+			continue
+		}
+		if !copied {
+			inner := make(map[string]string, len(scope)+1)
+			for p, u := range scope {
+				inner[p] = u
+			}
+			scope, copied = inner, true
+		}
+		if attr.Name == xmlnsPrefix {
+			scope[""] = attr.Value
+		} else {
+			scope[localName(attr.Name)] = attr.Value
+		}
+	}
+
+	undeclared := func(format string, args ...interface{}) error {
+		return fmt.Errorf("xmldom: %s on <%s> at offset %d", fmt.Sprintf(format, args...), n.Name, n.StartOffset)
+	}
+	if i := strings.IndexByte(n.QualifiedName(), ':'); i >= 0 {
+		if prefix := n.QualifiedName()[:i]; prefix != xmlPrefix {
+			if _, ok := scope[prefix]; !ok {
+				return undeclared("undeclared namespace prefix %q", prefix)
+			}
+		}
+	} else if n.NamespaceURI != "" && !bindsNamespace(scope, n.NamespaceURI) {
+		return undeclared("undeclared namespace %q", n.NamespaceURI)
+	}
+	for _, attr := range n.Attributes {
+		i := strings.IndexByte(attr.Name, ':')
+		if i < 0 || isNamespaceDecl(attr) {
+			continue
+		}
+		switch prefix := attr.Name[:i]; {
+		case prefix == xmlPrefix,
+			prefix == xlinkPrefix && attr.NamespaceURI == xlinkUrl,
+			prefix == xsiPrefix && attr.NamespaceURI == xsiUrl:
+		default:
+			if _, ok := scope[prefix]; !ok {
+				return undeclared("undeclared namespace prefix %q of attribute %s", prefix, attr.Name)
+			}
+		}
+	}
+
+	n.ensureChildren()
+	for _, c := range n.Children {
+		if err := validateNamespaces(c, scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindsNamespace tells if any of the declarations in scope binds the namespace URI.
+func bindsNamespace(scope map[string]string, uri string) bool {
+	for _, u := range scope {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// RenameNamespace moves the elements and attributes in the namespace with the old URI to the new
+// one, and rebinds the xmlns declarations of the old URI to the new one, for example to migrate
+// documents to a new version of a vocabulary. The prefixes are left unchanged.
+func (d *Document) RenameNamespace(oldURI, newURI string) {
+	if d.Root == nil {
+		return
+	}
+	d.Root.Walk(func(n *Node) {
+		if n.NamespaceURI == oldURI {
+			n.NamespaceURI = newURI
+		}
+		for _, attr := range n.Attributes {
+			if isNamespaceDecl(attr) {
+				if attr.Value == oldURI {
+					attr.Value = newURI
+				}
+			} else if attr.NamespaceURI == oldURI {
+				attr.NamespaceURI = newURI
+			}
+		}
+	})
+}
+
+// HoistNamespaces rewrites the namespace declarations of the document so that each namespace in
+// use is declared once, on the root, and removes all other declarations, as a transform to call
+// before serializing. The namespace of the root becomes the default namespace, unless there are
+// elements in no namespace, which a default would capture. Every other namespace, as well as the
+// default one when attributes are in it, is bound to a prefix, and the elements and attributes
+// are renamed with it.
+//
+// The prefixes are stable: a namespace keeps the first prefix the document uses or declares for
+// it, in document order, so a namespace bound to several prefixes ends up with the first one. A
+// prefix bound to several namespaces stays with the namespace used first, and the others, like
+// namespaces without any prefix, are given a generated one, ns1, ns2 and so on. The root lists
+// the declarations in the order the namespaces are first used.
+func (d *Document) HoistNamespaces() {
+	if d.Root == nil {
+		return
+	}
+
+	var uris []string
+	used := make(map[string]bool)
+	inAttributes := make(map[string]bool)
+	candidates := make(map[string]string)
+	unqualified := false
+	use := func(uri string) {
+		if !used[uri] {
+			used[uri] = true
+			uris = append(uris, uri)
+		}
+	}
+	suggest := func(uri, prefix string) {
+		if _, ok := candidates[uri]; !ok && prefix != "" {
+			candidates[uri] = prefix
+		}
+	}
+	d.Root.Walk(func(n *Node) {
+		if n.Type != ElementNode {
+			return
+		}
+		for _, attr := range n.Attributes {
+			if isNamespaceDecl(attr) && attr.Name != xmlnsPrefix {
+				suggest(attr.Value, localName(attr.Name))
+			}
+		}
+		if n.NamespaceURI == "" {
+			unqualified = true
+		} else if n.NamespaceURI != xmlUrl {
+			suggest(n.NamespaceURI, n.Prefix)
+			use(n.NamespaceURI)
+		}
+		for _, attr := range n.Attributes {
+			if isNamespaceDecl(attr) || attr.NamespaceURI == "" || attr.NamespaceURI == xmlUrl {
+				continue
+			}
+			if i := strings.IndexByte(attr.Name, ':'); i >= 0 {
+				suggest(attr.NamespaceURI, attr.Name[:i])
+			}
+			use(attr.NamespaceURI)
+			inAttributes[attr.NamespaceURI] = true
+		}
+	})
+
+	def := ""
+	if !unqualified {
+		def = d.Root.NamespaceURI
+	}
+	prefixes := make(map[string]string)
+	taken := map[string]bool{xmlPrefix: true, xmlnsPrefix: true}
+	generated := 0
+	for _, uri := range uris {
+		if uri == def && !inAttributes[uri] {
+			continue
+		}
+		prefix := candidates[uri]
+		for prefix == "" || taken[prefix] {
+			generated++
+			prefix = fmt.Sprintf("ns%d", generated)
+		}
+		taken[prefix] = true
+		prefixes[uri] = prefix
+	}
+
+	d.Root.Walk(func(n *Node) {
+		if n.Type != ElementNode {
+			return
+		}
+		switch n.NamespaceURI {
+		case "", def:
+			n.Prefix = ""
+		case xmlUrl:
+		default:
+			n.Prefix = prefixes[n.NamespaceURI]
+		}
+		attrs := make([]*Attribute, 0, len(n.Attributes))
+		for _, attr := range n.Attributes {
+			if isNamespaceDecl(attr) {
+				continue
+			}
+			if prefix, ok := prefixes[attr.NamespaceURI]; ok {
+				attr.Name = prefix + ":" + localName(attr.Name)
+			}
+			attrs = append(attrs, attr)
+		}
+		n.Attributes = attrs
+	})
+
+	var decls []*Attribute
+	if def != "" {
+		decls = append(decls, &Attribute{Name: xmlnsPrefix, Value: def, NamespaceURI: xmlnsUrl})
+	}
+	for _, uri := range uris {
+		if prefix, ok := prefixes[uri]; ok {
+			decls = append(decls, &Attribute{Name: xmlnsPrefix + ":" + prefix, Value: uri, NamespaceURI: xmlnsUrl})
+		}
+	}
+	d.Root.Attributes = append(decls, d.Root.Attributes...)
+}
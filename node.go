@@ -1,23 +1,190 @@
 package xmldom
 
-import "bytes"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// NodeType tells the kind of a node.
+type NodeType int
+
+const (
+	// ElementNode is an element, which is the zero value of the node type.
+	ElementNode NodeType = iota
+	// ProcInstNode is a processing instruction, holding its target as Name and its instruction as
+	// Text, such as <?xml-stylesheet href="style.css"?>.
+	ProcInstNode
+	// DirectiveNode is a directive in the prolog of a document, holding the text between <! and >
+	// as Text, such as DOCTYPE html.
+	DirectiveNode
+)
 
 type Node struct {
-	Document   *Document
-	Parent     *Node
-	Name       string
-	Attributes []*Attribute
-	Children   []*Node
-	Text       string
+	Document *Document
+	Parent   *Node
+	Type     NodeType
+	Name     string
+	// Prefix is the namespace prefix of a parsed element, as used in the source; Name holds its
+	// local part then. Elements created with a prefixed Name have no Prefix of their own.
+	Prefix       string
+	NamespaceURI string
+	Attributes   []*Attribute
+	Children     []*Node
+	Text         string
+	StartOffset  int64
+	EndOffset    int64
+	rawText      string
+	lazy         *lazyChildren
+	// unescaped tells if the Text is markup to serialize verbatim
+	unescaped bool
+	// textNodes holds the segments of the text as parsed, when there are several or when they mix
+	// with children, textNodesOf the Text they make up, to tell when Text was changed since, and
+	// textNodesAt the number of children preceding each segment. textSplits holds the offsets in
+	// the Text as parsed where the text before each child ends, when the text mixes with children.
+	textNodes   []string
+	textNodesOf string
+	textNodesAt []int
+	textSplits  []int
 }
 
+// Attribute is an attribute of an element. A parsed attribute in a namespace is named with the
+// prefix for its NamespaceURI, as in xlink:href, and an attribute without namespace by its local
+// name. An attribute in the default namespace for which there is no prefix, which is rare, but
+// possible when NamespacePrefixes maps the namespace to the empty prefix, is named by its local
+// name as well, while its NamespaceURI is kept, so GetAttributeValue finds it by that name.
 type Attribute struct {
-	Name  string
-	Value string
+	Name         string
+	Value        string
+	NamespaceURI string
 }
 
+// Root returns the topmost ancestor of the node, which is the document root for a node in the
+// document tree, and the top of the subtree for a detached node. It follows the Parent pointers,
+// so it does not depend on the Document pointer being current.
 func (n *Node) Root() *Node {
-	return n.Document.Root
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return n
+}
+
+// OwnerDocument returns the document the node belongs to. The Document pointer of the node is
+// trusted when set; otherwise, such as for nodes created or moved by manual tree surgery, it is
+// recomputed as the document of the topmost ancestor. It returns nil for a tree that is not part
+// of any document. Use Document.Reindex to repair stale pointers throughout a tree.
+func (n *Node) OwnerDocument() *Document {
+	if n.Document != nil {
+		return n.Document
+	}
+	return n.Root().Document
+}
+
+// SetText sets the text of the node. The text is stored raw, as is, and escaped by the serializer
+// when writing XML; so pass the text unescaped, as pre-escaped text would end up escaped twice.
+// It drops the raw text retained by the parser, so RawText returns the new text.
+func (n *Node) SetText(s string) *Node {
+	n.Text = s
+	n.rawText = ""
+	n.unescaped = false
+	return n
+}
+
+// TextNodes returns the text of the node split into its text nodes, in document order: the
+// chunks of char data between the child elements, comments, processing instructions and CDATA
+// sections, trimmed like Text is. Whitespace only chunks are left out, except where Text keeps
+// whitespace. A node with a single text node, or with Text changed since parsing, returns its
+// Text as the only segment; a node without text returns an empty slice.
+func (n *Node) TextNodes() []string {
+	if n.textNodes != nil && n.textNodesOf == n.Text {
+		return append([]string(nil), n.textNodes...)
+	}
+	if n.Text == "" {
+		return []string{}
+	}
+	return []string{n.Text}
+}
+
+// TextContent returns the text of the node and its descendants, concatenated in document order,
+// like the DOM textContent. The text of an element is made up of its text nodes as parsed, so
+// with the default parser settings, the whitespace around them is trimmed; parse with
+// PreserveWhitespace to keep the spacing between the pieces. Text assigned since parsing is taken
+// to follow the children of its element, where the serializer writes it.
+func (n *Node) TextContent() string {
+	return n.TextContentExcluding()
+}
+
+// TextContentExcluding returns the TextContent of the node, leaving out the subtrees of the
+// descendant elements with any of the given local names, such as script and style for the
+// visible text of an HTML like document.
+func (n *Node) TextContentExcluding(names ...string) string {
+	excluded := make(map[string]bool, len(names))
+	for _, name := range names {
+		excluded[name] = true
+	}
+	var b strings.Builder
+	n.writeTextContent(&b, excluded)
+	return b.String()
+}
+
+func (n *Node) writeTextContent(b *strings.Builder, excluded map[string]bool) {
+	n.ensureChildren()
+	segments, at := n.TextNodes(), n.textNodesAt
+	if n.textNodesOf != n.Text {
+		at = nil
+	}
+	i := 0
+	for k, c := range n.Children {
+		for ; i < len(segments) && at != nil && at[i] <= k; i++ {
+			b.WriteString(segments[i])
+		}
+		if c.Type == ElementNode && !excluded[c.Name] {
+			c.writeTextContent(b, excluded)
+		}
+	}
+	for ; i < len(segments); i++ {
+		b.WriteString(segments[i])
+	}
+}
+
+// SetUnsafeRawText sets the text of the node to markup that the serializer writes verbatim,
+// without escaping it, such as a pre-built XML signature block. This is UNSAFE: the text is not
+// checked in any way, so text that is not well-formed markup in its place produces invalid XML,
+// and text from untrusted sources allows injecting arbitrary content. The text remains verbatim
+// until SetText is called. Other methods, such as queries, see the text as it is.
+func (n *Node) SetUnsafeRawText(s string) *Node {
+	n.Text = s
+	n.rawText = ""
+	n.unescaped = true
+	return n
+}
+
+// RawText returns the text of the node as it was read, before whitespace trimming. It is only
+// retained when parsing with the RetainRawText option, otherwise the Text is returned. SetText
+// and SetUnsafeRawText drop the raw text, but assigning the Text field directly does not.
+func (n *Node) RawText() string {
+	if len(n.rawText) > 0 {
+		return n.rawText
+	}
+	return n.Text
+}
+
+// TextBytes returns the text of the node as a byte slice, without copying it. The decoder reuses
+// its buffers between tokens, so the text itself is always copied once during parse; this only
+// avoids the second copy a []byte(n.Text) conversion would make.
+//
+// The returned slice aliases the memory of the Text string and MUST NOT be modified, since Go
+// strings are immutable; writing to it is undefined behavior. It remains valid for as long as
+// the caller holds on to it, but no longer reflects the node once Text is reassigned.
+func (n *Node) TextBytes() []byte {
+	if len(n.Text) == 0 {
+		return nil
+	}
+	return unsafe.Slice(unsafe.StringData(n.Text), len(n.Text))
 }
 
 func (n *Node) GetAttribute(name string) *Attribute {
@@ -37,12 +204,150 @@ func (n *Node) GetAttributeValue(name string) string {
 	return ""
 }
 
+// Attr returns the value of the named attribute, or the empty string when it is missing, as a
+// shorthand of GetAttributeValue. Use GetAttribute to tell a missing attribute from an empty one.
+func (n *Node) Attr(name string) string {
+	return n.GetAttributeValue(name)
+}
+
+// GetAttributeValueDefault returns the value of the named attribute, or the given default when
+// the attribute is missing. An attribute with an empty value is not missing.
+func (n *Node) GetAttributeValueDefault(name, def string) string {
+	if attr := n.GetAttribute(name); attr != nil {
+		return attr.Value
+	}
+	return def
+}
+
+// GetAttributeFold finds the value of the named attribute, matching the name case-insensitively,
+// and tells whether the attribute was found. This suits HTML-like sources with varying case.
+func (n *Node) GetAttributeFold(name string) (string, bool) {
+	for _, attr := range n.Attributes {
+		if strings.EqualFold(attr.Name, name) {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAttributeInt parses the value of the named attribute as an int. It returns an error when the
+// attribute is missing, or its value is not an integer.
+func (n *Node) GetAttributeInt(name string) (int, error) {
+	value, err := n.requireAttributeValue(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// GetAttributeIntDefault parses the value of the named attribute as an int, returning the given
+// default when the attribute is missing or its value is not an integer.
+func (n *Node) GetAttributeIntDefault(name string, def int) int {
+	if v, err := n.GetAttributeInt(name); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetAttributeFloat parses the value of the named attribute as a float64. It returns an error
+// when the attribute is missing, or its value is not a number.
+func (n *Node) GetAttributeFloat(name string) (float64, error) {
+	value, err := n.requireAttributeValue(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// GetAttributeBool parses the value of the named attribute as a bool, accepting the values that
+// strconv.ParseBool does, which includes the xsd:boolean values true, false, 1 and 0. It returns
+// an error when the attribute is missing, or its value is not a boolean.
+func (n *Node) GetAttributeBool(name string) (bool, error) {
+	value, err := n.requireAttributeValue(name)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+// GetAttributeTokens splits the value of the named attribute into its tokens, separated by XML
+// whitespace, as in the class attribute of SVG. It returns an empty slice when the attribute is
+// missing, or holds no tokens.
+func (n *Node) GetAttributeTokens(name string) []string {
+	return strings.FieldsFunc(n.GetAttributeValue(name), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// HasToken tells if the token is one of the tokens of the named attribute, such as a class of an
+// SVG element.
+func (n *Node) HasToken(name, token string) bool {
+	for _, t := range n.GetAttributeTokens(name) {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) requireAttributeValue(name string) (string, error) {
+	attr := n.GetAttribute(name)
+	if attr == nil {
+		return "", fmt.Errorf("xmldom: missing attribute %s on <%s>", name, n.Name)
+	}
+	return attr.Value, nil
+}
+
 func (n *Node) SetAttributeValue(name string, value string) *Node {
 	attr := n.GetAttribute(name)
 	if attr != nil {
 		attr.Value = value
 	} else {
-		n.Attributes = append(n.Attributes, &Attribute{name, value})
+		n.Attributes = append(n.Attributes, &Attribute{Name: name, Value: value})
+	}
+	return n
+}
+
+// SetAttributeInt sets the value of the named attribute to the decimal form of the integer.
+func (n *Node) SetAttributeInt(name string, v int) *Node {
+	return n.SetAttributeValue(name, strconv.Itoa(v))
+}
+
+// SetAttributeFloat sets the value of the named attribute to the shortest decimal form that
+// parses back to the same float64, such as 0.1 or 2.5e-07; exponents are used for very large and
+// very small values. Infinities and NaN are written as +Inf, -Inf and NaN, which GetAttributeFloat
+// reads back, but which are not valid xsd:double values. The form is that of strconv.FormatFloat
+// with the 'g' format and precision -1.
+func (n *Node) SetAttributeFloat(name string, v float64) *Node {
+	return n.SetAttributeValue(name, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// SetAttributeBool sets the value of the named attribute to true or false, the canonical
+// xsd:boolean forms.
+func (n *Node) SetAttributeBool(name string, v bool) *Node {
+	return n.SetAttributeValue(name, strconv.FormatBool(v))
+}
+
+// SetAttributes sets the values of multiple attributes. Existing attributes are updated in place,
+// new ones are appended in the order of their names, as maps have no order of their own. Use
+// SetAttributesOrdered to control the order.
+func (n *Node) SetAttributes(attrs map[string]string) *Node {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n.SetAttributeValue(name, attrs[name])
+	}
+	return n
+}
+
+// SetAttributesOrdered sets the values of multiple attributes, given as name and value pairs.
+// Existing attributes are updated in place, new ones are appended in the order given.
+func (n *Node) SetAttributesOrdered(pairs [][2]string) *Node {
+	for _, pair := range pairs {
+		n.SetAttributeValue(pair[0], pair[1])
 	}
 	return n
 }
@@ -58,8 +363,9 @@ func (n *Node) RemoveAttribute(name string) *Node {
 }
 
 func (n *Node) GetChild(name string) *Node {
+	n.ensureChildren()
 	for _, c := range n.Children {
-		if c.Name == name {
+		if c.Type == ElementNode && c.Name == name {
 			return c
 		}
 	}
@@ -67,16 +373,67 @@ func (n *Node) GetChild(name string) *Node {
 }
 
 func (n *Node) GetChildren(name string) []*Node {
+	n.ensureChildren()
 	var nodes []*Node
 	for _, c := range n.Children {
-		if c.Name == name {
+		if c.Type == ElementNode && c.Name == name {
 			nodes = append(nodes, c)
 		}
 	}
 	return nodes
 }
 
+// ChildText returns the text of the first direct child element with the given name, and whether
+// such a child was found. Like GetChild, only direct children are considered.
+func (n *Node) ChildText(name string) (string, bool) {
+	if c := n.GetChild(name); c != nil {
+		return c.Text, true
+	}
+	return "", false
+}
+
+// ChildTexts returns the text of each direct child element with the given name, in document
+// order. It returns an empty slice when there is no such child.
+func (n *Node) ChildTexts(name string) []string {
+	texts := []string{}
+	for _, c := range n.GetChildren(name) {
+		texts = append(texts, c.Text)
+	}
+	return texts
+}
+
+// HasChild tells if the node has a direct child element with the given name.
+func (n *Node) HasChild(name string) bool {
+	return n.GetChild(name) != nil
+}
+
+// HasDescendant tells if the subtree below the node holds an element with the given name,
+// stopping at the first one found.
+func (n *Node) HasDescendant(name string) bool {
+	n.ensureChildren()
+	for _, c := range n.Children {
+		if c.FindOneByName(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Elements returns the child elements of the node, leaving out other children, such as processing
+// instructions. It returns an empty slice for a node without child elements.
+func (n *Node) Elements() []*Node {
+	n.ensureChildren()
+	elements := make([]*Node, 0, len(n.Children))
+	for _, c := range n.Children {
+		if c.Type == ElementNode {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
 func (n *Node) FirstChild() *Node {
+	n.ensureChildren()
 	if len(n.Children) > 0 {
 		return n.Children[0]
 	}
@@ -84,17 +441,31 @@ func (n *Node) FirstChild() *Node {
 }
 
 func (n *Node) LastChild() *Node {
+	n.ensureChildren()
 	if l := len(n.Children); l > 0 {
 		return n.Children[l-1]
 	}
 	return nil
 }
 
+// Index returns the zero-based position of the node among the children of its parent, or -1 when
+// it has no parent.
+func (n *Node) Index() int {
+	if n.Parent != nil {
+		for i, c := range n.Parent.Children {
+			if c == n {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 func (n *Node) PrevSibling() *Node {
 	if n.Parent != nil {
 		for i, c := range n.Parent.Children {
 			if c == n {
-				if i >= 0 {
+				if i > 0 {
 					return n.Parent.Children[i-1]
 				}
 				return nil
@@ -127,13 +498,56 @@ func (n *Node) CreateNode(name string) *Node {
 }
 
 func (n *Node) AppendChild(c *Node) *Node {
+	n.ensureChildren()
 	c.Document = n.Document
 	c.Parent = n
 	n.Children = append(n.Children, c)
 	return n
 }
 
+// ErrNotChild is returned when a reference node is not a child of the node operated on.
+var ErrNotChild = errors.New("xmldom: reference node is not a child")
+
+// InsertBefore inserts the new child into the children of the node, right before the reference
+// child, or at the end when ref is nil. A new child that is part of a tree already is moved. It
+// returns ErrNotChild when ref is not a child of the node.
+func (n *Node) InsertBefore(newChild, ref *Node) error {
+	if ref == nil {
+		newChild.Detach()
+		n.AppendChild(newChild)
+		return nil
+	}
+	return n.insertAt(newChild, ref, 0)
+}
+
+// InsertAfter inserts the new child into the children of the node, right after the reference
+// child, which appends it when ref is the last child. A new child that is part of a tree already
+// is moved. It returns ErrNotChild when ref is not a child of the node.
+func (n *Node) InsertAfter(newChild, ref *Node) error {
+	return n.insertAt(newChild, ref, 1)
+}
+
+// insertAt inserts the new child at the given offset from the position of the reference child.
+func (n *Node) insertAt(newChild, ref *Node, offset int) error {
+	n.ensureChildren()
+	if ref == nil || ref.Parent != n || ref.Index() < 0 {
+		return ErrNotChild
+	}
+	if newChild == ref {
+		return nil
+	}
+	newChild.Detach()
+	i := ref.Index() + offset
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = newChild
+	newChild.Document = n.Document
+	newChild.Parent = n
+	return nil
+}
+
 func (n *Node) RemoveChild(c *Node) *Node {
+	n.ensureChildren()
 	for i, a := range n.Children {
 		if a == c {
 			n.Children = append(n.Children[:i], n.Children[i+1:]...)
@@ -143,7 +557,185 @@ func (n *Node) RemoveChild(c *Node) *Node {
 	return n
 }
 
+// Rename changes the name of the element. It does not validate the new name, see RenameChecked.
+func (n *Node) Rename(newName string) *Node {
+	n.Name = newName
+	return n
+}
+
+// RenameChecked changes the name of the element, provided the new name is a legal XML name.
+func (n *Node) RenameChecked(newName string) error {
+	if !isXMLName(newName) {
+		return fmt.Errorf("xmldom: illegal element name %q", newName)
+	}
+	n.Name = newName
+	return nil
+}
+
+// RenameNS changes both the namespace and the local name of the element.
+// The Prefix is dropped when the namespace changes, as it is bound to the former one.
+func (n *Node) RenameNS(uri, local string) *Node {
+	if uri != n.NamespaceURI {
+		n.Prefix = ""
+	}
+	n.NamespaceURI = uri
+	n.Name = local
+	return n
+}
+
+// QualifiedName returns the name of the node as it is serialized, including its namespace
+// prefix, such as svg:rect.
+func (n *Node) QualifiedName() string {
+	if n.Prefix != "" {
+		return n.Prefix + ":" + n.Name
+	}
+	return n.Name
+}
+
+// QualifiedName returns the name of the attribute as it is serialized, which is its Name, such
+// as xlink:href.
+func (a *Attribute) QualifiedName() string {
+	return a.Name
+}
+
+// SortChildren stably reorders the direct children of the node by the given comparison. The
+// descendants further down are left as they are.
+func (n *Node) SortChildren(less func(a, b *Node) bool) *Node {
+	n.ensureChildren()
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		return less(n.Children[i], n.Children[j])
+	})
+	return n
+}
+
+// SortChildrenByName stably reorders the direct children of the node by their name.
+func (n *Node) SortChildrenByName() *Node {
+	return n.SortChildren(func(a, b *Node) bool {
+		return a.Name < b.Name
+	})
+}
+
+// Clone makes a deep copy of the node and its subtree, not sharing any attributes or children
+// with the original. The clone has no parent, but belongs to the same document.
+func (n *Node) Clone() *Node {
+	n.ensureChildren()
+	c := *n
+	c.Parent = nil
+	c.Attributes = nil
+	for _, attr := range n.Attributes {
+		a := *attr
+		c.Attributes = append(c.Attributes, &a)
+	}
+	c.Children = nil
+	c.lazy = nil
+	for _, child := range n.Children {
+		cc := child.Clone()
+		cc.Parent = &c
+		c.Children = append(c.Children, cc)
+	}
+	return &c
+}
+
+// ToDocument makes a deep copy of the node as the root of a new document, with the default XML
+// declaration, for example to serialize a fragment found in a larger document on its own. The
+// namespace declarations the node inherits from its ancestors are copied onto the new root, so
+// the fragment stays self-contained.
+func (n *Node) ToDocument() *Document {
+	d := &Document{ProcInst: DEFAULT_XML_HEADER}
+	d.Root = n.Clone()
+	for p := n.Parent; p != nil; p = p.Parent {
+		for _, attr := range p.Attributes {
+			if isNamespaceDecl(attr) && d.Root.GetAttribute(attr.Name) == nil {
+				a := *attr
+				d.Root.Attributes = append(d.Root.Attributes, &a)
+			}
+		}
+	}
+	reindex(d, d.Root)
+	return d
+}
+
+// LowestCommonAncestor returns the deepest node that is an ancestor of both given nodes, or nil
+// when they are in different trees. A node counts as its own ancestor, so when one node is an
+// ancestor of the other, that node is returned.
+func LowestCommonAncestor(a, b *Node) *Node {
+	ancestors := make(map[*Node]bool)
+	for n := a; n != nil; n = n.Parent {
+		ancestors[n] = true
+	}
+	for n := b; n != nil; n = n.Parent {
+		if ancestors[n] {
+			return n
+		}
+	}
+	return nil
+}
+
+// Detach removes the node from the children of its parent, keeping its own subtree intact. It is
+// a no-op for a node without parent.
+func (n *Node) Detach() *Node {
+	if n.Parent != nil {
+		n.Parent.RemoveChild(n)
+		n.Parent = nil
+	}
+	return n
+}
+
+// FilterResult is the decision of a NodeFilter about a node in a walk.
+type FilterResult int
+
+const (
+	// FilterAccept visits the node and walks its children.
+	FilterAccept FilterResult = iota
+	// FilterSkip skips the node, but still walks its children.
+	FilterSkip
+	// FilterReject skips the node along with its subtree.
+	FilterReject
+)
+
+// NodeFilter decides which nodes a walk visits and descends into, like the DOM NodeFilter.
+type NodeFilter func(n *Node) FilterResult
+
+// Walk calls visit for the node and each of its descendants, in document order.
+func (n *Node) Walk(visit func(n *Node)) {
+	n.WalkFiltered(nil, visit)
+}
+
+// WalkFiltered calls visit for the node and its descendants in document order, as far as the
+// filter accepts them. Rejecting a node prunes its subtree from the walk without looking at it,
+// which saves time in large trees. A nil filter accepts all nodes.
+func (n *Node) WalkFiltered(filter NodeFilter, visit func(n *Node)) {
+	result := FilterAccept
+	if filter != nil {
+		result = filter(n)
+	}
+	switch result {
+	case FilterReject:
+		return
+	case FilterAccept:
+		visit(n)
+	}
+
+	n.ensureChildren()
+	for _, c := range n.Children {
+		c.WalkFiltered(filter, visit)
+	}
+}
+
+// MapNodes calls f for the node and each of its descendants, in document order, which visits each
+// node before its children, and collects the values for which f returns true.
+func MapNodes[T any](n *Node, f func(n *Node) (T, bool)) []T {
+	var values []T
+	n.Walk(func(n *Node) {
+		if v, ok := f(n); ok {
+			values = append(values, v)
+		}
+	})
+	return values
+}
+
 func (n *Node) FindByID(id string) *Node {
+	n.ensureChildren()
 	if n.GetAttributeValue("id") == id {
 		return n
 	}
@@ -158,7 +750,8 @@ func (n *Node) FindByID(id string) *Node {
 }
 
 func (n *Node) FindOneByName(name string) *Node {
-	if n.Name == name {
+	n.ensureChildren()
+	if n.Type == ElementNode && n.Name == name {
 		return n
 	}
 
@@ -172,23 +765,218 @@ func (n *Node) FindOneByName(name string) *Node {
 }
 
 func (n *Node) FindByName(name string) []*Node {
+	return n.AppendByName(nil, name)
+}
+
+// AppendByName appends the nodes with the given name to dst, like FindByName finds them, and
+// returns the extended slice. Reusing a buffer this way avoids allocations in tight loops.
+func (n *Node) AppendByName(dst []*Node, name string) []*Node {
+	n.ensureChildren()
+	if n.Type == ElementNode && n.Name == name {
+		dst = append(dst, n)
+	}
+
+	for _, c := range n.Children {
+		dst = c.AppendByName(dst, name)
+	}
+
+	return dst
+}
+
+// CountByName counts the nodes with the given name, like FindByName finds them, without
+// collecting them in a slice.
+func (n *Node) CountByName(name string) int {
+	n.ensureChildren()
+	count := 0
+	if n.Type == ElementNode && n.Name == name {
+		count++
+	}
+
+	for _, c := range n.Children {
+		count += c.CountByName(name)
+	}
+
+	return count
+}
+
+// FindByAttr finds the elements with the named attribute set to the given value among the node
+// and its descendants, like FindByName. The value "*" is a wildcard, matching any value, so
+// FindByAttr("class", "*") finds all elements with a class attribute. To match a literal "*"
+// instead, pass the escaped form `\*`.
+func (n *Node) FindByAttr(name, value string) []*Node {
+	var match func(v string) bool
+	switch value {
+	case "*":
+		match = func(string) bool { return true }
+	case `\*`:
+		match = func(v string) bool { return v == "*" }
+	default:
+		match = func(v string) bool { return v == value }
+	}
+
+	var nodes []*Node
+	n.Walk(func(e *Node) {
+		if attr := e.GetAttribute(name); e.Type == ElementNode && attr != nil && match(attr.Value) {
+			nodes = append(nodes, e)
+		}
+	})
+	return nodes
+}
+
+// WithAttribute returns the elements having the named attribute, whatever its value, among the
+// node and its descendants, like FindByName, in document order. It returns an empty slice when
+// no element has the attribute.
+func (n *Node) WithAttribute(name string) []*Node {
+	nodes := []*Node{}
+	n.Walk(func(e *Node) {
+		if e.Type == ElementNode && e.GetAttribute(name) != nil {
+			nodes = append(nodes, e)
+		}
+	})
+	return nodes
+}
+
+// FindByLocalName finds the nodes with the given local name, ignoring any prefix. Parsed elements
+// store just their local name as Name, which FindByName matches exactly; nodes created with a
+// prefixed name, such as CreateNode("svg:rect"), are only found as "rect" by FindByLocalName. To
+// match by both local name and namespace, use FindByNameNS.
+func (n *Node) FindByLocalName(local string) []*Node {
+	n.ensureChildren()
+	var nodes []*Node
+
+	if n.Type == ElementNode && localName(n.Name) == local {
+		nodes = append(nodes, n)
+	}
+
+	for _, c := range n.Children {
+		nodes = append(nodes, c.FindByLocalName(local)...)
+	}
+
+	return nodes
+}
+
+// FindByNames finds the nodes with any of the given local names, like FindByLocalName finds
+// them for a single name, in document order.
+func (n *Node) FindByNames(names ...string) []*Node {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return n.appendByNames(nil, set)
+}
+
+func (n *Node) appendByNames(dst []*Node, names map[string]bool) []*Node {
+	n.ensureChildren()
+	if n.Type == ElementNode && names[localName(n.Name)] {
+		dst = append(dst, n)
+	}
+
+	for _, c := range n.Children {
+		dst = c.appendByNames(dst, names)
+	}
+
+	return dst
+}
+
+// FindByNameNS finds the nodes with the given local name in the given namespace. Unlike
+// FindByName, elements sharing a local name across different namespaces are told apart.
+func (n *Node) FindByNameNS(uri, name string) []*Node {
+	n.ensureChildren()
 	var nodes []*Node
 
-	if n.Name == name {
+	if n.Type == ElementNode && n.Name == name && n.NamespaceURI == uri {
 		nodes = append(nodes, n)
 	}
 
 	for _, c := range n.Children {
-		nodes = append(nodes, c.FindByName(name)...)
+		nodes = append(nodes, c.FindByNameNS(uri, name)...)
 	}
 
 	return nodes
 }
 
+// RemoveNamespace strips the descendant elements and the attributes in the namespace with the
+// given URI from the subtree, together with the xmlns declarations of that namespace. The node
+// itself is kept, even when it is in the namespace. Elements and attributes are matched by their
+// NamespaceURI, which the parser sets, but nodes created programmatically may lack.
+func (n *Node) RemoveNamespace(uri string) *Node {
+	n.ensureChildren()
+	var attrs []*Attribute
+	for _, attr := range n.Attributes {
+		if attr.NamespaceURI == uri || isNamespaceDecl(attr) && attr.Value == uri {
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attributes = attrs
+
+	var children []*Node
+	for _, c := range n.Children {
+		if c.NamespaceURI == uri {
+			continue
+		}
+		children = append(children, c.RemoveNamespace(uri))
+	}
+	n.Children = children
+	return n
+}
+
+// DeclareNamespace declares the namespace with the given URI on the element, bound to the prefix,
+// or as the default namespace for the empty prefix. An existing declaration of the prefix is
+// updated. It does not validate the prefix, see DeclareNamespaceChecked.
+func (n *Node) DeclareNamespace(prefix, uri string) *Node {
+	if prefix == "" {
+		return n.setNamespaceDecl(xmlnsPrefix, uri)
+	}
+	return n.setNamespaceDecl(xmlnsPrefix+":"+prefix, uri)
+}
+
+// DeclareNamespaceChecked declares the namespace like DeclareNamespace, provided the prefix is a
+// legal namespace prefix, which excludes the reserved xmlns prefix, and the xml prefix bound to
+// anything but its own namespace.
+func (n *Node) DeclareNamespaceChecked(prefix, uri string) error {
+	if prefix != "" && (!isXMLName(prefix) || strings.Contains(prefix, ":") || prefix == xmlnsPrefix || prefix == xmlPrefix && uri != xmlUrl) {
+		return fmt.Errorf("xmldom: illegal namespace prefix %q", prefix)
+	}
+	n.DeclareNamespace(prefix, uri)
+	return nil
+}
+
+func (n *Node) setNamespaceDecl(name, uri string) *Node {
+	if attr := n.GetAttribute(name); attr != nil {
+		attr.Value = uri
+	} else {
+		n.Attributes = append(n.Attributes, &Attribute{Name: name, Value: uri, NamespaceURI: xmlnsUrl})
+	}
+	return n
+}
+
+// Query finds the nodes matched by the xpath expression, see https://github.com/antchfx/xpath for
+// the full grammar. Matched attribute and text nodes resolve to the element holding them. The
+// Text of an element is its text() node, which follows the child elements. The nodes are
+// returned in document order without duplicates, also for union expressions such as
+// //title | //item. Predicates commonly used for extraction are supported, such as:
+//
+//	//item[position()=2]
+//	//item[last()]
+//	//item[text()='foo']
+//	//item[@id='foo']
+//
+// As in XPath, positions count among the siblings matched under each parent, so //item[2] is the
+// second item of every parent that has one; (//item)[2] is the second item in the document.
+// The extension function depth() returns the nesting level of the context node, where the root
+// is at depth 1, as in //*[depth()=2] for the children of the root. Query panics when the
+// expression is invalid, see QueryChecked for the error returning variant.
 func (n *Node) Query(xpath string) []*Node {
 	return xpathQuery(n, xpath)
 }
 
+// QueryChecked finds the nodes matched by the xpath expression like Query does, but returns an
+// error when the expression is invalid, or uses a function that is not supported.
+func (n *Node) QueryChecked(xpath string) ([]*Node, error) {
+	return xpathQueryChecked(n, xpath)
+}
+
 func (n *Node) QueryOne(xpath string) *Node {
 	return xpathQueryOne(n, xpath)
 }
@@ -199,18 +987,25 @@ func (n *Node) QueryEach(xpath string, cb func(int, *Node)) {
 
 func (n *Node) XML() string {
 	buf := new(bytes.Buffer)
-	printXML(buf, n, 0, "")
+	new(domSerializerSettings).printXML(buf, n, 0, false)
 	return buf.String()
 }
 
 func (n *Node) XMLPretty() string {
 	buf := new(bytes.Buffer)
-	printXML(buf, n, 0, "  ")
+	(&domSerializerSettings{indent: "  "}).printXML(buf, n, 0, false)
 	return buf.String()
 }
 
 func (n *Node) XMLPrettyEx(indent string) string {
 	buf := new(bytes.Buffer)
-	printXML(buf, n, 0, indent)
+	(&domSerializerSettings{indent: indent}).printXML(buf, n, 0, false)
+	return buf.String()
+}
+
+// XMLCompact serializes the node without any insignificant whitespace, see Document.XMLCompact.
+func (n *Node) XMLCompact() string {
+	buf := new(bytes.Buffer)
+	(&domSerializerSettings{compact: true}).printXML(buf, n, 0, false)
 	return buf.String()
 }
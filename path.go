@@ -0,0 +1,256 @@
+package xmldom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueryPath finds the nodes matched by a path in a small subset of XPath, evaluated without the
+// XPath engine behind Query. Like Query, attribute and text() steps resolve to the element holding
+// them, and the nodes are returned in document order without duplicates. The subset is:
+//
+//	Path      = [ "/" | "//" ] Step { ( "/" | "//" ) Step } | "/" .
+//	Step      = NameTest { Predicate } | "@" Name | "text()" .
+//	NameTest  = Name | "*" .
+//	Predicate = "[" "@" Name "=" Literal "]" .
+//	Literal   = "'" { char } "'" | '"' { char } '"' .
+//
+// A path starting with / is evaluated from the top of the tree, with the topmost element as its
+// only child, and any other path from the node itself. A // means any number of levels in
+// between. A name with a prefix is matched against the qualified name of an element, any other
+// name against its local name. The @ and text() steps may only come last. Anything outside the
+// subset, such as axes, positional predicates or functions, is an error.
+func (n *Node) QueryPath(path string) ([]*Node, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The context of an absolute path is the document node, which is modeled as a node with the
+	// topmost element as only child, and marked by the nil slot on the context list.
+	context := []*Node{n}
+	if strings.HasPrefix(path, "/") {
+		context = []*Node{nil}
+	}
+	top := n.Root()
+	for _, s := range steps {
+		seen := make(map[*Node]bool)
+		var next []*Node
+		for _, c := range context {
+			next = s.apply(c, top, next, seen)
+		}
+		context = next
+	}
+	if len(context) == 1 && context[0] == nil {
+		return []*Node{top}, nil
+	}
+	sortDocumentOrder(context)
+	return context, nil
+}
+
+// pathStep is a single step of a path in the QueryPath subset.
+type pathStep struct {
+	descendant bool // preceded by //
+	name       string
+	attr       string // the @attr step, when set
+	text       bool   // the text() step
+	predicates [][2]string
+}
+
+// apply appends the nodes the step selects from the context node to dst, skipping those seen
+// before. A nil context node is the document node, whose only child is top.
+func (s *pathStep) apply(c, top *Node, dst []*Node, seen map[*Node]bool) []*Node {
+	// A // step selects from the descendants, where attributes and text are looked up on the
+	// context node itself as well.
+	last := s.attr != "" || s.text
+	var candidates []*Node
+	switch {
+	case c == nil && s.descendant:
+		candidates = descendants(top, true)
+	case c == nil && !last:
+		candidates = []*Node{top}
+	case c == nil:
+		// The document node has neither attributes nor text.
+	case s.descendant:
+		candidates = descendants(c, last)
+	case last:
+		candidates = []*Node{c}
+	default:
+		c.ensureChildren()
+		candidates = c.Children
+	}
+	for _, e := range candidates {
+		if !seen[e] && s.matches(e) {
+			seen[e] = true
+			dst = append(dst, e)
+		}
+	}
+	return dst
+}
+
+// descendants returns the nodes below the given one in document order, preceded by the node
+// itself when self is set.
+func descendants(n *Node, self bool) []*Node {
+	var all []*Node
+	n.Walk(func(d *Node) {
+		if self || d != n {
+			all = append(all, d)
+		}
+	})
+	return all
+}
+
+func (s *pathStep) matches(e *Node) bool {
+	switch {
+	case e.Type != ElementNode:
+		return false
+	case s.text:
+		return len(e.Text) > 0
+	case s.attr != "":
+		return e.GetAttribute(s.attr) != nil
+	}
+	switch {
+	case s.name == "*":
+	case strings.Contains(s.name, ":"):
+		if e.QualifiedName() != s.name {
+			return false
+		}
+	case e.Name != s.name:
+		return false
+	}
+	for _, p := range s.predicates {
+		if attr := e.GetAttribute(p[0]); attr == nil || attr.Value != p[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePath parses a path in the QueryPath subset into its steps. The lone / of the document
+// node parses into no steps at all.
+func parsePath(path string) ([]*pathStep, error) {
+	p := &pathParser{path: path}
+	if path == "" {
+		return nil, p.errorf("empty path")
+	}
+	if path == "/" {
+		return nil, nil
+	}
+
+	var steps []*pathStep
+	for first := true; p.pos < len(path); first = false {
+		s := new(pathStep)
+		switch {
+		case strings.HasPrefix(path[p.pos:], "//"):
+			s.descendant = true
+			p.pos += 2
+		case strings.HasPrefix(path[p.pos:], "/"):
+			p.pos++
+		case !first:
+			return nil, p.errorf("expected / or //")
+		}
+		if len(steps) > 0 && (steps[len(steps)-1].attr != "" || steps[len(steps)-1].text) {
+			return nil, p.errorf("step after an attribute or text() step")
+		}
+		if err := p.step(s); err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+type pathParser struct {
+	path string
+	pos  int
+}
+
+func (p *pathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("xmldom: unsupported path %q at offset %d: %s", p.path, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *pathParser) step(s *pathStep) error {
+	switch {
+	case strings.HasPrefix(p.path[p.pos:], "text()"):
+		s.text = true
+		p.pos += len("text()")
+		return nil
+	case strings.HasPrefix(p.path[p.pos:], "@"):
+		p.pos++
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		s.attr = name
+		return nil
+	case strings.HasPrefix(p.path[p.pos:], "*"):
+		s.name = "*"
+		p.pos++
+	default:
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		s.name = name
+	}
+
+	for strings.HasPrefix(p.path[p.pos:], "[") {
+		p.pos++
+		if !strings.HasPrefix(p.path[p.pos:], "@") {
+			return p.errorf("only [@attr='value'] predicates are supported")
+		}
+		p.pos++
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(p.path[p.pos:], "=") {
+			return p.errorf("expected =")
+		}
+		p.pos++
+		value, err := p.literal()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(p.path[p.pos:], "]") {
+			return p.errorf("expected ]")
+		}
+		p.pos++
+		s.predicates = append(s.predicates, [2]string{name, value})
+	}
+	return nil
+}
+
+// name reads an XML name, which may hold a single prefix.
+func (p *pathParser) name() (string, error) {
+	end := len(p.path)
+	for i, r := range p.path[p.pos:] {
+		if !isNameStartChar(r) && !isNameChar(r) {
+			end = p.pos + i
+			break
+		}
+	}
+	name := p.path[p.pos:end]
+	if strings.Contains(name, "::") || strings.HasPrefix(p.path[end:], "(") {
+		return "", p.errorf("functions and axes are not supported")
+	}
+	if !isXMLName(name) || strings.Count(name, ":") > 1 || strings.HasSuffix(name, ":") {
+		return "", p.errorf("expected a name")
+	}
+	p.pos = end
+	return name, nil
+}
+
+func (p *pathParser) literal() (string, error) {
+	if p.pos >= len(p.path) || p.path[p.pos] != '\'' && p.path[p.pos] != '"' {
+		return "", p.errorf("expected a quoted value")
+	}
+	quote := p.path[p.pos]
+	end := strings.IndexByte(p.path[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated value")
+	}
+	value := p.path[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return value, nil
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func stringifyProcInst(pi *xml.ProcInst) string {
@@ -21,56 +24,515 @@ func stringifyDirective(directive *xml.Directive) string {
 	return fmt.Sprintf("<!%s>", string(*directive))
 }
 
-func printXML(buf *bytes.Buffer, n *Node, level int, indent string) {
-	pretty := len(indent) > 0
+// DOMSerializer serializes a DOM into XML text. It is configurable, allowing the user to control
+// some features of the output, such as indentation and the XML declaration.
+type DOMSerializer interface {
+	Serialize(d *Document) string
+	SerializeNodes(nodes []*Node) string
+	WriteNodes(w io.Writer, nodes []*Node) error
+	WriteFile(filename string, d *Document) error
+	Indent(indent string) DOMSerializer
+	Compact(f bool) DOMSerializer
+	OmitDeclaration(f bool) DOMSerializer
+	WriteBOM(f bool) DOMSerializer
+	EscapePolicy(policy EscapePolicy) DOMSerializer
+	TrailingNewline(f bool) DOMSerializer
+	MinimizeNamespaces(f bool) DOMSerializer
+	MaxIndentDepth(depth int) DOMSerializer
+	AttributeFilter(filter func(n *Node, a *Attribute) bool) DOMSerializer
+	NewlineStyle(style NewlineStyle) DOMSerializer
+	InlineShortElements(maxLen int) DOMSerializer
+	WrapAttributes(maxLineLen int) DOMSerializer
+}
+
+type domSerializerSettings struct {
+	indent             string
+	compact            bool
+	omitDeclaration    bool
+	writeBOM           bool
+	escape             EscapePolicy
+	trailingNewline    bool
+	minimizeNamespaces bool
+	maxIndentDepth     int
+	attributeFilter    func(n *Node, a *Attribute) bool
+	newlineStyle       NewlineStyle
+	inlineMaxLen       int
+	wrapLineLen        int
+	// measureLimit is set on a scratch pass that only measures whether an element fits on a line
+	// of that many characters, which stops writing once it is known not to.
+	measureLimit int
+}
+
+// NewlineStyle is the line ending written by the serializer.
+type NewlineStyle int
+
+const (
+	// NewlineLF ends lines with a line feed, as on Unix, which is the default.
+	NewlineLF NewlineStyle = iota
+	// NewlineCRLF ends lines with a carriage return and a line feed, as on Windows.
+	NewlineCRLF
+	// NewlineCR ends lines with a carriage return, as on classic Mac OS.
+	NewlineCR
+)
+
+// EscapePolicy escapes the text and attribute values for output. Custom policies can be supplied
+// for fussy consumers; MinimalEscape escapes the least that keeps the output well-formed.
+type EscapePolicy func(s string) string
+
+var (
+	// MinimalEscape escapes only what is needed for well-formed output: '&', '<', '"', and '>'
+	// where it ends the sequence "]]>", which text may not hold.
+	MinimalEscape EscapePolicy = strings.NewReplacer("]]>", "]]&gt;", "&", "&amp;", "<", "&lt;", `"`, "&#34;").Replace
+
+	// StandardEscape escapes like encoding/xml does, which is the default: '&', '<', '>', '\'',
+	// '"', and the tab, newline and carriage return characters as character references. Invalid
+	// characters are replaced by U+FFFD.
+	StandardEscape EscapePolicy = func(s string) string {
+		buf := new(bytes.Buffer)
+		_ = xml.EscapeText(buf, []byte(s))
+		return buf.String()
+	}
+
+	// AggressiveEscape escapes like StandardEscape, and additionally writes all characters beyond
+	// ASCII as numeric character references, for consumers that mishandle encodings.
+	AggressiveEscape EscapePolicy = func(s string) string {
+		buf := new(bytes.Buffer)
+		for _, r := range StandardEscape(s) {
+			if r > unicode.MaxASCII {
+				fmt.Fprintf(buf, "&#x%X;", r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+		return buf.String()
+	}
+)
+
+func NewDOMSerializer() DOMSerializer {
+	return &domSerializerSettings{}
+}
+
+// Indent pretty prints the output, indenting nested elements with the given string. Without
+// indent, which is the default, the output is on a single line.
+func (s *domSerializerSettings) Indent(indent string) DOMSerializer {
+	s.indent = indent
+	return s
+}
+
+// Compact trims insignificant whitespace from text, outside the xml:space="preserve" scopes.
+func (s *domSerializerSettings) Compact(f bool) DOMSerializer {
+	s.compact = f
+	return s
+}
+
+// OmitDeclaration suppresses the XML declaration held in the ProcInst of the document, such as
+// when embedding the output in a larger document. By default, the declaration is written when the
+// document has one. A raw prolog set with Document.SetProlog is left out as a whole.
+func (s *domSerializerSettings) OmitDeclaration(f bool) DOMSerializer {
+	s.omitDeclaration = f
+	return s
+}
+
+// WriteBOM starts the output with a UTF-8 byte order mark. The parser strips the mark when
+// reading, so this is the way to keep it in a round trip.
+func (s *domSerializerSettings) WriteBOM(f bool) DOMSerializer {
+	s.writeBOM = f
+	return s
+}
+
+// EscapePolicy sets the policy for escaping text and attribute values, StandardEscape by default.
+func (s *domSerializerSettings) EscapePolicy(policy EscapePolicy) DOMSerializer {
+	s.escape = policy
+	return s
+}
+
+// TrailingNewline ends the output with a newline, as many tools expect text files to. Output
+// that ends with a newline already, such as pretty printed output, does not get another one.
+func (s *domSerializerSettings) TrailingNewline(f bool) DOMSerializer {
+	s.trailingNewline = f
+	return s
+}
+
+// MinimizeNamespaces writes each namespace declaration only once, at the highest element where
+// it can be declared, and leaves it out where the same binding is in scope already. A prefix
+// bound to different namespaces in different parts of the tree is declared where each of its
+// bindings is needed. Default namespace declarations are never moved, as that would change the
+// namespace of the unprefixed elements around them.
+func (s *domSerializerSettings) MinimizeNamespaces(f bool) DOMSerializer {
+	s.minimizeNamespaces = f
+	return s
+}
 
+// MaxIndentDepth limits pretty printing to the elements up to the given depth, where the root is
+// at depth 0. An element at the depth is indented on its own line, with all of its content
+// written on that line as it would be without indent. The text of the elements is never changed,
+// so mixed content beyond the depth comes out as it is, while mixed content at shallower depths
+// gets the indentation of pretty printing around its child elements. Zero, the default, means
+// no limit.
+func (s *domSerializerSettings) MaxIndentDepth(depth int) DOMSerializer {
+	s.maxIndentDepth = depth
+	return s
+}
+
+// AttributeFilter leaves the attributes out of the output for which the filter returns false,
+// without changing the tree. The filter is called for each attribute of each element, in the
+// order the attributes are written, which is their order in the Attributes of the element. With
+// MinimizeNamespaces, it is called after the namespace declarations were minimized, so it sees the
+// declarations moved to the element as well, and filtering a declaration out removes it from
+// the output.
+func (s *domSerializerSettings) AttributeFilter(filter func(n *Node, a *Attribute) bool) DOMSerializer {
+	s.attributeFilter = filter
+	return s
+}
+
+// NewlineStyle sets the line ending of the output, used for the line breaks of pretty printing and
+// by TrailingNewline. Newlines within text and attribute values are escaped, so they are not
+// affected. NewlineLF is the default.
+func (s *domSerializerSettings) NewlineStyle(style NewlineStyle) DOMSerializer {
+	s.newlineStyle = style
+	return s
+}
+
+// InlineShortElements keeps an element with child elements on a single line when pretty printing,
+// provided it takes no more than maxLen characters there, not counting the indentation. Longer
+// elements are broken across lines as usual, which is also what zero, the default, does for all.
+func (s *domSerializerSettings) InlineShortElements(maxLen int) DOMSerializer {
+	s.inlineMaxLen = maxLen
+	return s
+}
+
+// WrapAttributes puts each attribute of a start tag on a line of its own when pretty printing,
+// if the tag would otherwise make its line longer than maxLineLen characters, counting the
+// indentation and the closing > or />. The wrapped attributes are indented one level deeper than
+// the element, and the tag is closed right after the last one. Tags that fit stay on one line,
+// as do all tags when maxLineLen is zero, the default.
+func (s *domSerializerSettings) WrapAttributes(maxLineLen int) DOMSerializer {
+	s.wrapLineLen = maxLineLen
+	return s
+}
+
+func (s *domSerializerSettings) newline() string {
+	switch s.newlineStyle {
+	case NewlineCRLF:
+		return "\r\n"
+	case NewlineCR:
+		return "\r"
+	}
+	return "\n"
+}
+
+// Serialize the document into XML text, using the serializer settings from the receiver.
+func (s *domSerializerSettings) Serialize(d *Document) string {
+	buf := new(bytes.Buffer)
+	s.printDocument(buf, d)
+	return buf.String()
+}
+
+// SerializeNodes serializes the nodes as a fragment, see WriteNodes.
+func (s *domSerializerSettings) SerializeNodes(nodes []*Node) string {
+	buf := new(bytes.Buffer)
+	s.printNodes(buf, nodes)
+	return buf.String()
+}
+
+// WriteNodes writes the nodes to the writer as a fragment: one after the other, without the XML
+// declaration or a common parent, using the serializer settings from the receiver. Each node is
+// serialized as its document would serialize it, in the xml:space scope of its ancestors.
+func (s *domSerializerSettings) WriteNodes(w io.Writer, nodes []*Node) error {
+	buf := new(bytes.Buffer)
+	s.printNodes(buf, nodes)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// SerializeNodes serializes the nodes as a fragment, using default serializer settings.
+func SerializeNodes(nodes []*Node) string {
+	return NewDOMSerializer().SerializeNodes(nodes)
+}
+
+// WriteNodes writes the nodes to the writer as a fragment, using default serializer settings.
+func WriteNodes(w io.Writer, nodes []*Node) error {
+	return NewDOMSerializer().WriteNodes(w, nodes)
+}
+
+// writeDocument writes the XML text of the document to the writer, returning the number of bytes
+// written.
+func (s *domSerializerSettings) writeDocument(w io.Writer, d *Document) (int64, error) {
+	buf := new(bytes.Buffer)
+	s.printDocument(buf, d)
+	return buf.WriteTo(w)
+}
+
+// WriteFile writes the XML text of the document to the named file, using the serializer settings
+// from the receiver. The file is written atomically, see Document.WriteFilePretty.
+func (s *domSerializerSettings) WriteFile(filename string, d *Document) error {
+	return writeFileAtomic(filename, []byte(s.Serialize(d)))
+}
+
+func (s *domSerializerSettings) printNodes(buf *bytes.Buffer, nodes []*Node) {
+	for _, n := range nodes {
+		preserve := false
+		for p := n.Parent; p != nil; p = p.Parent {
+			if v := p.GetAttributeValue("xml:space"); v != "" {
+				preserve = v == "preserve"
+				break
+			}
+		}
+		s.printXML(buf, n, 0, preserve)
+	}
+	s.printTrailingNewline(buf)
+}
+
+func (s *domSerializerSettings) printDocument(buf *bytes.Buffer, d *Document) {
+	pretty := len(s.indent) > 0
+
+	if s.writeBOM {
+		buf.Write(utf8BOM)
+	}
+	if d.rawProlog != "" {
+		if !s.omitDeclaration {
+			buf.WriteString(d.rawProlog)
+		}
+	} else {
+		if len(d.ProcInst) > 0 && !s.omitDeclaration {
+			buf.WriteString(d.ProcInst)
+			if pretty {
+				buf.WriteString(s.newline())
+			}
+		}
+		if d.Prolog != nil {
+			for _, item := range d.Prolog {
+				s.printXML(buf, item, 0, false)
+			}
+		} else {
+			for _, directive := range d.Directives {
+				buf.WriteString(directive)
+				if pretty {
+					buf.WriteString(s.newline())
+				}
+			}
+		}
+	}
+	s.printXML(buf, d.Root, 0, false)
+	for _, item := range d.Epilog {
+		s.printXML(buf, item, 0, false)
+	}
+	s.printTrailingNewline(buf)
+}
+
+func (s *domSerializerSettings) printTrailingNewline(buf *bytes.Buffer) {
+	if s.trailingNewline && (buf.Len() == 0 || !bytes.HasSuffix(buf.Bytes(), []byte(s.newline()))) {
+		buf.WriteString(s.newline())
+	}
+}
+
+func (s *domSerializerSettings) writeEscaped(buf *bytes.Buffer, text string) {
+	if s.overMeasureLimit(len(text)) {
+		// escaping never shortens text, so a prefix this long already settles the measurement
+		text = text[:s.measureLimit*utf8.UTFMax+1]
+	}
+	if s.escape == nil {
+		_ = xml.EscapeText(buf, []byte(text))
+	} else {
+		buf.WriteString(s.escape(text))
+	}
+}
+
+// overMeasureLimit tells if a scratch pass measuring an element has written enough bytes for it
+// to take more characters than the limit, however many bytes each of them takes.
+func (s *domSerializerSettings) overMeasureLimit(n int) bool {
+	return s.measureLimit > 0 && n > s.measureLimit*utf8.UTFMax
+}
+
+// writeAttributes writes the attributes, each preceded by the separator.
+func (s *domSerializerSettings) writeAttributes(buf *bytes.Buffer, attrs []*Attribute, sep string) {
+	for _, attr := range attrs {
+		buf.WriteString(sep)
+		buf.WriteString(attr.Name)
+		buf.WriteByte('=')
+		buf.WriteByte('"')
+		s.writeEscaped(buf, attr.Value)
+		buf.WriteByte('"')
+	}
+}
+
+// preserveSpace tells if whitespace is significant in the given node, given the xml:space scope
+// of its parent.
+func preserveSpace(n *Node, inherited bool) bool {
+	switch n.GetAttributeValue("xml:space") {
+	case "preserve":
+		return true
+	case "default":
+		return false
+	}
+	return inherited
+}
+
+// textPieces splits the text of an element with mixed content into the pieces before each child
+// and after the last, as parsed, trimmed in compact form like the Text is. It returns nil when
+// all of the text follows the children, which is where the Text is written otherwise: when there
+// is no text before them, or when the Text or the children were changed since parsing.
+func (s *domSerializerSettings) textPieces(n *Node, preserve bool) []string {
+	if n.textSplits == nil || n.unescaped || n.textNodesOf != n.Text || len(n.textSplits) != len(n.Children) {
+		return nil
+	}
+	pieces := make([]string, 0, len(n.Children)+1)
+	start := 0
+	for _, end := range n.textSplits {
+		pieces = append(pieces, n.Text[start:end])
+		start = end
+	}
+	pieces = append(pieces, n.Text[start:])
+	if s.compact && !preserve {
+		pieces[0] = strings.TrimLeftFunc(pieces[0], unicode.IsSpace)
+		pieces[len(pieces)-1] = strings.TrimRightFunc(pieces[len(pieces)-1], unicode.IsSpace)
+		for i, piece := range pieces {
+			if strings.TrimSpace(piece) == "" {
+				pieces[i] = ""
+			}
+		}
+	}
+	for _, piece := range pieces[:len(pieces)-1] {
+		if piece != "" {
+			return pieces
+		}
+	}
+	return nil
+}
+
+func (s *domSerializerSettings) printXML(buf *bytes.Buffer, n *Node, level int, preserve bool) {
+	var m *namespaceMinimizer
+	if s.minimizeNamespaces {
+		m = &namespaceMinimizer{subtree: make(map[*Node]map[string]string)}
+	}
+	s.printNode(buf, n, level, preserve, m, nil)
+}
+
+// printNode writes a node and its descendants. The minimizer is nil unless namespaces are to be
+// minimized, in which case scope holds the prefixes declared by the ancestors written so far.
+func (s *domSerializerSettings) printNode(buf *bytes.Buffer, n *Node, level int, preserve bool, m *namespaceMinimizer, scope map[string]string) {
+	if s.overMeasureLimit(buf.Len()) {
+		return
+	}
+	n.ensureChildren()
+	indent := s.indent
+	pretty := len(indent) > 0 && (s.maxIndentDepth <= 0 || level <= s.maxIndentDepth)
+	prettyChildren := pretty && (s.maxIndentDepth <= 0 || level < s.maxIndentDepth)
+	if n.Type == ProcInstNode || n.Type == DirectiveNode {
+		if pretty {
+			buf.WriteString(strings.Repeat(indent, level))
+		}
+		if n.Type == ProcInstNode {
+			buf.WriteString(stringifyProcInst(&xml.ProcInst{Target: n.Name, Inst: []byte(n.Text)}))
+		} else {
+			directive := xml.Directive(n.Text)
+			buf.WriteString(stringifyDirective(&directive))
+		}
+		if pretty {
+			buf.WriteString(s.newline())
+		}
+		return
+	}
+	if prettyChildren && s.inlineMaxLen > 0 && len(n.Children) > 0 {
+		inline := *s
+		inline.indent = ""
+		inline.measureLimit = s.inlineMaxLen
+		short := new(bytes.Buffer)
+		inline.printNode(short, n, level, preserve, m, scope)
+		if utf8.RuneCount(short.Bytes()) <= s.inlineMaxLen {
+			buf.WriteString(strings.Repeat(indent, level))
+			_, _ = short.WriteTo(buf)
+			buf.WriteString(s.newline())
+			return
+		}
+	}
+	preserve = preserveSpace(n, preserve)
+
+	text := n.Text
+	if s.compact && !preserve && !n.unescaped {
+		text = strings.TrimSpace(text)
+	}
+
+	lineStart := buf.Len()
 	if pretty {
 		buf.WriteString(strings.Repeat(indent, level))
 	}
 	buf.WriteByte('<')
-	buf.WriteString(n.Name)
+	buf.WriteString(n.QualifiedName())
 
-	if len(n.Attributes) > 0 {
-		for _, attr := range n.Attributes {
-			buf.WriteByte(' ')
-			buf.WriteString(attr.Name)
-			buf.WriteByte('=')
-			buf.WriteByte('"')
-			xml.Escape(buf, []byte(attr.Value))
-			buf.WriteByte('"')
+	attrs := n.Attributes
+	if m != nil {
+		attrs, scope = m.minimize(n, scope)
+	}
+	if s.attributeFilter != nil {
+		kept := make([]*Attribute, 0, len(attrs))
+		for _, attr := range attrs {
+			if s.attributeFilter(n, attr) {
+				kept = append(kept, attr)
+			}
+		}
+		attrs = kept
+	}
+	empty := len(n.Children) == 0 && len(text) == 0
+	tagEnd := buf.Len()
+	s.writeAttributes(buf, attrs, " ")
+	if pretty && s.wrapLineLen > 0 && len(attrs) > 0 {
+		closing := len(">")
+		if empty {
+			closing = len(" />")
+		}
+		if utf8.RuneCount(buf.Bytes()[lineStart:])+closing > s.wrapLineLen {
+			buf.Truncate(tagEnd)
+			s.writeAttributes(buf, attrs, s.newline()+strings.Repeat(indent, level+1))
 		}
 	}
 
-	if len(n.Children) == 0 && len(n.Text) == 0 {
+	if empty {
 		buf.WriteString(" />")
 		if pretty {
-			buf.WriteByte('\n')
+			buf.WriteString(s.newline())
 		}
 		return
 	}
 
 	buf.WriteByte('>')
 
-	if len(n.Children) > 0 {
-		if pretty {
-			buf.WriteByte('\n')
+	if pieces := s.textPieces(n, preserve); pieces != nil {
+		// mixed content is written in document order, without indenting the children
+		inline := *s
+		inline.indent = ""
+		for k, c := range n.Children {
+			s.writeEscaped(buf, pieces[k])
+			inline.printNode(buf, c, level+1, preserve, m, scope)
 		}
-		for _, c := range n.Children {
-			printXML(buf, c, level+1, indent)
+		s.writeEscaped(buf, pieces[len(pieces)-1])
+		prettyChildren = false
+	} else {
+		if len(n.Children) > 0 {
+			if prettyChildren {
+				buf.WriteString(s.newline())
+			}
+			for _, c := range n.Children {
+				s.printNode(buf, c, level+1, preserve, m, scope)
+			}
+		}
+		if len(text) > 0 {
+			if n.unescaped {
+				buf.WriteString(text)
+			} else {
+				s.writeEscaped(buf, text)
+			}
 		}
-	}
-	if len(n.Text) > 0 {
-		xml.EscapeText(buf, []byte(n.Text))
 	}
 
-	if len(n.Children) > 0 && len(indent) > 0 {
+	if len(n.Children) > 0 && prettyChildren {
 		buf.WriteString(strings.Repeat(indent, level))
 	}
 	buf.WriteString("</")
-	buf.WriteString(n.Name)
+	buf.WriteString(n.QualifiedName())
 	buf.WriteByte('>')
 
 	if pretty {
-		buf.WriteByte('\n')
+		buf.WriteString(s.newline())
 	}
 }
@@ -1,6 +1,9 @@
 package xmldom
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/antchfx/xpath"
 )
 
@@ -9,52 +12,185 @@ func createXPathNavigator(top *Node) xpath.NodeNavigator {
 	return &xmlNodeNavigator{curr: top, attrIndex: -1}
 }
 
+// xpathQueryChecked compiles the specified XPath expr, and searches the Node that matches by it.
+func xpathQueryChecked(top *Node, expr string) ([]*Node, error) {
+	e, err := xpath.Compile(expandExtensions(expr))
+	if err != nil {
+		return nil, err
+	}
+	return selectNodes(e.Select(createXPathNavigator(top))), nil
+}
+
 // xpathQuery searches the Node that matches by the specified XPath expr.
 func xpathQuery(top *Node, expr string) []*Node {
-	t := xpath.Select(createXPathNavigator(top), expr)
-	var nodes []*Node
-	for t.MoveNext() {
-		nodes = append(nodes, (t.Current().(*xmlNodeNavigator)).curr)
-	}
-	return nodes
+	return selectNodes(xpath.Select(createXPathNavigator(top), expandExtensions(expr)))
 }
 
 // xpathQueryOne searches the Node that matches by the specified XPath expr,
 // and returns first element of matched.
 func xpathQueryOne(top *Node, expr string) *Node {
-	t := xpath.Select(createXPathNavigator(top), expr)
-	if t.MoveNext() {
-		return (t.Current().(*xmlNodeNavigator)).curr
+	if nodes := xpathQuery(top, expr); len(nodes) > 0 {
+		return nodes[0]
 	}
 	return nil
 }
 
 // xpathQueryEach searches the xmldom.Node and calls functions cb.
 func xpathQueryEach(top *Node, expr string, cb func(int, *Node)) {
-	t := xpath.Select(createXPathNavigator(top), expr)
-	var i int
+	for i, n := range xpathQuery(top, expr) {
+		cb(i, n)
+	}
+}
+
+// depthExpansion computes the depth of the context node. The navigator presents the topmost
+// element as the root node, so it has no ancestors and is at depth 1.
+const depthExpansion = "(count(ancestor::node())+1)"
+
+// expandExtensions rewrites the extension functions in the expression into standard XPath, which
+// the engine evaluates natively. String literals are left alone.
+func expandExtensions(expr string) string {
+	if !strings.Contains(expr, "depth") {
+		return expr
+	}
+	var b strings.Builder
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				b.WriteString(expr[i:])
+				return b.String()
+			}
+			b.WriteString(expr[i : i+end+2])
+			i += end + 2
+		case strings.HasPrefix(expr[i:], "depth") && (i == 0 || !isNameChar(rune(expr[i-1]))):
+			rest := strings.TrimLeft(expr[i+len("depth"):], " \t\r\n")
+			if !strings.HasPrefix(rest, "(") {
+				b.WriteString("depth")
+				i += len("depth")
+				break
+			}
+			rest = strings.TrimLeft(rest[1:], " \t\r\n")
+			if !strings.HasPrefix(rest, ")") {
+				b.WriteString("depth")
+				i += len("depth")
+				break
+			}
+			b.WriteString(depthExpansion)
+			i = len(expr) - len(rest) + 1
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// selectNodes collects the nodes selected by the iterator in document order, without duplicates.
+// The engine returns the results of a union in no particular order, and matched attribute and
+// text nodes resolve to their element, which may be matched already.
+func selectNodes(t *xpath.NodeIterator) []*Node {
+	seen := make(map[*Node]bool)
+	var nodes []*Node
 	for t.MoveNext() {
-		cb(i, (t.Current().(*xmlNodeNavigator)).curr)
-		i++
+		n := (t.Current().(*xmlNodeNavigator)).curr
+		if !seen[n] {
+			seen[n] = true
+			nodes = append(nodes, n)
+		}
 	}
+	sortDocumentOrder(nodes)
+	return nodes
 }
 
+// sortDocumentOrder sorts nodes of the same tree into document order.
+func sortDocumentOrder(nodes []*Node) {
+	if len(nodes) < 2 {
+		return
+	}
+
+	top := nodes[0]
+	for top.Parent != nil {
+		top = top.Parent
+	}
+	order := make(map[*Node]int)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		order[n] = len(order)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(top)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return order[nodes[i]] < order[nodes[j]]
+	})
+}
+
+// firstElement returns the first child of the node that is an element.
+func firstElement(n *Node) *Node {
+	n.ensureChildren()
+	for _, c := range n.Children {
+		if c.Type == ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+// lastElement returns the last child of the node that is an element.
+func lastElement(n *Node) *Node {
+	n.ensureChildren()
+	for i := len(n.Children) - 1; i >= 0; i-- {
+		if n.Children[i].Type == ElementNode {
+			return n.Children[i]
+		}
+	}
+	return nil
+}
+
+// siblingElement returns the nearest sibling of the node that is an element, in the direction
+// given by step.
+func siblingElement(n *Node, step int) *Node {
+	if n.Parent == nil {
+		return nil
+	}
+	children := n.Parent.Children
+	for i := n.Index() + step; i >= 0 && i < len(children); i += step {
+		if children[i].Type == ElementNode {
+			return children[i]
+		}
+	}
+	return nil
+}
+
+// xmlNodeNavigator navigates the elements and their attributes, skipping other types of nodes.
+// The Text of a node is presented as an extra text node, following the child elements of the
+// node. The engine computes position() and last() by moving among the siblings of a node, so the
+// text node is reached from the elements before it, and the other way around.
 type xmlNodeNavigator struct {
 	curr      *Node
 	attrIndex int
+	text      bool
 }
 
 func (x *xmlNodeNavigator) NodeType() xpath.NodeType {
-	if x.curr == x.curr.Root() {
-		return xpath.RootNode
+	if x.text {
+		return xpath.TextNode
 	}
 	if x.attrIndex != -1 {
 		return xpath.AttributeNode
 	}
+	if x.curr == x.curr.Root() {
+		return xpath.RootNode
+	}
 	return xpath.ElementNode
 }
 
 func (x *xmlNodeNavigator) LocalName() string {
+	if x.text {
+		return ""
+	}
 	if x.attrIndex != -1 {
 		return x.curr.Attributes[x.attrIndex].Name
 	}
@@ -79,9 +215,19 @@ func (x *xmlNodeNavigator) Copy() xpath.NodeNavigator {
 
 func (x *xmlNodeNavigator) MoveToRoot() {
 	x.curr = x.curr.Root()
+	x.attrIndex = -1
+	x.text = false
 }
 
 func (x *xmlNodeNavigator) MoveToParent() bool {
+	if x.attrIndex != -1 {
+		x.attrIndex = -1
+		return true
+	}
+	if x.text {
+		x.text = false
+		return true
+	}
 	if node := x.curr.Parent; node != nil {
 		x.curr = node
 		return true
@@ -90,7 +236,7 @@ func (x *xmlNodeNavigator) MoveToParent() bool {
 }
 
 func (x *xmlNodeNavigator) MoveToNextAttribute() bool {
-	if x.attrIndex >= len(x.curr.Attributes)-1 {
+	if x.text || x.attrIndex >= len(x.curr.Attributes)-1 {
 		return false
 	}
 	x.attrIndex++
@@ -98,16 +244,33 @@ func (x *xmlNodeNavigator) MoveToNextAttribute() bool {
 }
 
 func (x *xmlNodeNavigator) MoveToChild() bool {
-	if node := x.curr.FirstChild(); node != nil {
+	if x.text || x.attrIndex != -1 {
+		return false
+	}
+	if node := firstElement(x.curr); node != nil {
 		x.curr = node
 		return true
 	}
+	if len(x.curr.Text) > 0 {
+		x.text = true
+		return true
+	}
 	return false
 }
 
 func (x *xmlNodeNavigator) MoveToFirst() bool {
+	if x.attrIndex != -1 {
+		return false
+	}
+	if x.text {
+		if node := firstElement(x.curr); node != nil {
+			x.curr = node
+			x.text = false
+		}
+		return true
+	}
 	if x.curr.Parent != nil {
-		node := x.curr.Parent.FirstChild()
+		node := firstElement(x.curr.Parent)
 		if node != nil {
 			x.curr = node
 			return true
@@ -117,7 +280,18 @@ func (x *xmlNodeNavigator) MoveToFirst() bool {
 }
 
 func (x *xmlNodeNavigator) MoveToPrevious() bool {
-	node := x.curr.PrevSibling()
+	if x.attrIndex != -1 {
+		return false
+	}
+	if x.text {
+		if node := lastElement(x.curr); node != nil {
+			x.curr = node
+			x.text = false
+			return true
+		}
+		return false
+	}
+	node := siblingElement(x.curr, -1)
 	if node != nil {
 		x.curr = node
 		return true
@@ -126,11 +300,19 @@ func (x *xmlNodeNavigator) MoveToPrevious() bool {
 }
 
 func (x *xmlNodeNavigator) MoveToNext() bool {
-	node := x.curr.NextSibling()
+	if x.text || x.attrIndex != -1 {
+		return false
+	}
+	node := siblingElement(x.curr, 1)
 	if node != nil {
 		x.curr = node
 		return true
 	}
+	if parent := x.curr.Parent; parent != nil && len(parent.Text) > 0 {
+		x.curr = parent
+		x.text = true
+		return true
+	}
 	return false
 }
 
@@ -142,6 +324,7 @@ func (x *xmlNodeNavigator) MoveTo(other xpath.NodeNavigator) bool {
 
 	x.curr = node.curr
 	x.attrIndex = node.attrIndex
+	x.text = node.text
 	return true
 }
 
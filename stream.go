@@ -0,0 +1,333 @@
+package xmldom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamHandler receives the events of a streaming parse, as an alternative to building a DOM.
+// It sees the same content the DOM would: elements, text, comments, CDATA sections, processing
+// instructions and directives. An error returned from any of the methods aborts the parse, and is
+// returned as is from ParseStream.
+type StreamHandler interface {
+	StartElement(uri, name string, attrs []*Attribute) error
+	EndElement(uri, name string) error
+	CharData(text string) error
+	Comment(text string) error
+	CDATA(text string) error
+	ProcInst(target, inst string) error
+	Directive(text string) error
+}
+
+// ParseStream parses the XML text from the given reader, reporting each token to the handler in
+// document order. Character data is reported untrimmed. A leading byte order mark is skipped.
+func ParseStream(r io.Reader, h StreamHandler) error {
+	br, _ := stripBOM(r)
+	rr := &recordingReader{r: br}
+	p := xml.NewDecoder(rr)
+	namer := new(attributeNamer)
+	for {
+		start := p.InputOffset()
+		rr.discard(start)
+
+		t, err := p.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch token := t.(type) {
+		case xml.StartElement:
+			err = h.StartElement(token.Name.Space, token.Name.Local, namer.push(token.Attr))
+		case xml.EndElement:
+			namer.pop()
+			err = h.EndElement(token.Name.Space, token.Name.Local)
+		case xml.CharData:
+			if bytes.HasPrefix(rr.since(start), cdataStart) {
+				err = h.CDATA(string(token))
+			} else {
+				err = h.CharData(string(token))
+			}
+		case xml.Comment:
+			err = h.Comment(string(token))
+		case xml.ProcInst:
+			err = h.ProcInst(token.Target, string(token.Inst))
+		case xml.Directive:
+			err = h.Directive(string(token))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var cdataStart = []byte("<![CDATA[")
+
+// recordingReader keeps the bytes read from the source since the last discarded offset, so that
+// the raw form of a token can be inspected after the decoder returned it. The decoder needs to
+// look one byte ahead at most, which is why the recording is done by absolute offset.
+type recordingReader struct {
+	r      *bufio.Reader
+	buf    []byte
+	offset int64
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := rr.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = b
+	return 1, nil
+}
+
+func (rr *recordingReader) ReadByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err == nil {
+		rr.buf = append(rr.buf, b)
+	}
+	return b, err
+}
+
+// discard forgets the recorded bytes before the given offset.
+func (rr *recordingReader) discard(offset int64) {
+	rr.buf = rr.buf[offset-rr.offset:]
+	rr.offset = offset
+}
+
+// since returns the recorded bytes from the given offset on.
+func (rr *recordingReader) since(offset int64) []byte {
+	return rr.buf[offset-rr.offset:]
+}
+
+// StreamDecoder decodes a sequence of XML documents from a single reader, such as the messages
+// of an XML over socket protocol. Each document ends with its root element.
+type StreamDecoder struct {
+	r   io.Reader
+	b   *domBuilder
+	err error
+}
+
+// NewStreamDecoder creates a decoder for the documents read from the given reader, using default
+// parser settings. Nothing is read before the first call to Next.
+func NewStreamDecoder(r io.Reader) *StreamDecoder {
+	return &StreamDecoder{r: r}
+}
+
+// Next decodes the next document, blocking until it has been read completely. It returns io.EOF
+// once the input ends without holding another document.
+func (d *StreamDecoder) Next() (*Document, error) {
+	if d.b == nil && d.err == nil {
+		d.b, d.err = new(domParserSettings).newBuilder(d.r)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	doc, err := d.b.build(true)
+	if err != nil {
+		d.err = err
+	}
+	return doc, err
+}
+
+// TreeBuilder is a StreamHandler that builds a DOM from the events it receives, bridging the
+// streaming parse and the DOM. It builds the nodes like the parser does with its default
+// settings: comments are dropped, the text of an element, CDATA sections included, has its
+// leading and trailing whitespace trimmed, and processing instructions after the root go to the
+// Epilog. Offsets are not known to a handler, so they are left zero.
+//
+// To build a DOM of a selected subtree only, wrap the builder in a handler that forwards the
+// events from the start of the subtree up to its matching end, and skips the others, see the
+// example. The first element forwarded becomes the root. Forwarding a second root is an error.
+type TreeBuilder struct {
+	tree  domTree
+	namer attributeNamer
+}
+
+// NewTreeBuilder creates a builder for a new, empty document.
+func NewTreeBuilder() *TreeBuilder {
+	return &TreeBuilder{tree: domTree{settings: new(domParserSettings), doc: new(Document)}}
+}
+
+// Document returns the document built so far. Its Root is nil before the first element.
+func (b *TreeBuilder) Document() *Document {
+	return b.tree.doc
+}
+
+// StartElement opens an element, as a child of the open element or as the root.
+func (b *TreeBuilder) StartElement(uri, name string, attrs []*Attribute) error {
+	if b.tree.e == nil && b.tree.doc.Root != nil {
+		return fmt.Errorf("xmldom: second root element <%s>", name)
+	}
+	b.namer.push(namespaceDecls(attrs))
+	b.tree.startElement(&Node{
+		Name:         name,
+		NamespaceURI: uri,
+		Prefix:       b.namer.elementPrefix(uri),
+		Attributes:   attrs,
+	})
+	return nil
+}
+
+// EndElement closes the open element.
+func (b *TreeBuilder) EndElement(uri, name string) error {
+	if b.tree.e == nil {
+		return fmt.Errorf("xmldom: unexpected end element </%s>", name)
+	}
+	b.tree.endElement()
+	b.namer.pop()
+	return nil
+}
+
+// CharData adds the text to the open element. Text outside the root is dropped.
+func (b *TreeBuilder) CharData(text string) error {
+	if b.tree.e != nil {
+		b.tree.charData(text)
+	}
+	return nil
+}
+
+// Comment drops the comment, as the DOM has no comment nodes.
+func (b *TreeBuilder) Comment(text string) error {
+	return nil
+}
+
+// CDATA adds the content of the section to the open element, like CharData.
+func (b *TreeBuilder) CDATA(text string) error {
+	return b.CharData(text)
+}
+
+// ProcInst adds the processing instruction where the parser would put it.
+func (b *TreeBuilder) ProcInst(target, inst string) error {
+	b.tree.procInst(target, inst, 0, 0)
+	return nil
+}
+
+// Directive adds the directive to the document.
+func (b *TreeBuilder) Directive(text string) error {
+	b.tree.directive(text, 0, 0)
+	return nil
+}
+
+// namespaceDecls converts the namespace declarations among the attributes back to the decoded
+// form the attributeNamer reads, so the prefixes are resolved the same way as by the parser.
+func namespaceDecls(attrs []*Attribute) []xml.Attr {
+	var decls []xml.Attr
+	for _, attr := range attrs {
+		switch {
+		case attr.Name == xmlnsPrefix:
+			decls = append(decls, xml.Attr{Name: xml.Name{Local: xmlnsPrefix}, Value: attr.Value})
+		case isNamespaceDecl(attr):
+			decls = append(decls, xml.Attr{Name: xml.Name{Space: xmlnsPrefix, Local: localName(attr.Name)}, Value: attr.Value})
+		}
+	}
+	return decls
+}
+
+// ParseElement streams through the XML text from the given reader, building a DOM only for the
+// first element with the given local name, and stops reading right after it. This extracts a
+// part of a large input without building the DOM of the whole. The element is returned as the
+// root of a document of its own, like the TreeBuilder builds it, with the namespace declarations
+// it inherits from its ancestors copied onto it. It returns ErrNotFound when the input ends
+// without such an element.
+func ParseElement(r io.Reader, name string) (*Node, error) {
+	x := &elementExtractor{name: name, b: NewTreeBuilder()}
+	switch err := ParseStream(r, x); err {
+	case errElementDone:
+		return x.b.Document().Root, nil
+	case nil:
+		return nil, ErrNotFound
+	default:
+		return nil, err
+	}
+}
+
+// errElementDone stops the stream of ParseElement once the element has been built.
+var errElementDone = errors.New("xmldom: element done")
+
+// elementExtractor forwards the events of the first element with the name to the builder, while
+// tracking the namespace declarations of the elements around it.
+type elementExtractor struct {
+	name   string
+	b      *TreeBuilder
+	depth  int
+	scopes [][]*Attribute
+}
+
+func (x *elementExtractor) StartElement(uri, name string, attrs []*Attribute) error {
+	if x.depth == 0 {
+		if name != x.name {
+			x.scopes = append(x.scopes, attrs)
+			return nil
+		}
+		for i := len(x.scopes) - 1; i >= 0; i-- {
+			for _, attr := range x.scopes[i] {
+				if isNamespaceDecl(attr) && !hasAttribute(attrs, attr.Name) {
+					attrs = append(attrs, attr)
+				}
+			}
+		}
+	}
+	x.depth++
+	return x.b.StartElement(uri, name, attrs)
+}
+
+func (x *elementExtractor) EndElement(uri, name string) error {
+	if x.depth == 0 {
+		x.scopes = x.scopes[:len(x.scopes)-1]
+		return nil
+	}
+	x.depth--
+	if err := x.b.EndElement(uri, name); err != nil {
+		return err
+	}
+	if x.depth == 0 {
+		return errElementDone
+	}
+	return nil
+}
+
+func (x *elementExtractor) CharData(text string) error {
+	if x.depth == 0 {
+		return nil
+	}
+	return x.b.CharData(text)
+}
+
+func (x *elementExtractor) Comment(text string) error {
+	return nil
+}
+
+func (x *elementExtractor) CDATA(text string) error {
+	return x.CharData(text)
+}
+
+func (x *elementExtractor) ProcInst(target, inst string) error {
+	if x.depth == 0 {
+		return nil
+	}
+	return x.b.ProcInst(target, inst)
+}
+
+func (x *elementExtractor) Directive(text string) error {
+	return nil
+}
+
+// hasAttribute tells if any of the attributes has the name.
+func hasAttribute(attrs []*Attribute, name string) bool {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return true
+		}
+	}
+	return false
+}
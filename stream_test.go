@@ -0,0 +1,188 @@
+package xmldom_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rtenhove/go-xmldom"
+	"io"
+	"strings"
+	"testing"
+)
+
+type recordingHandler struct {
+	events []string
+	abort  string
+}
+
+func (h *recordingHandler) record(event string) error {
+	h.events = append(h.events, event)
+	if event == h.abort {
+		return errors.New("abort")
+	}
+	return nil
+}
+
+func (h *recordingHandler) StartElement(uri, name string, attrs []*xmldom.Attribute) error {
+	return h.record(fmt.Sprintf("start %s %d", name, len(attrs)))
+}
+
+func (h *recordingHandler) EndElement(uri, name string) error {
+	return h.record("end " + name)
+}
+
+func (h *recordingHandler) CharData(text string) error {
+	return h.record("text " + text)
+}
+
+func (h *recordingHandler) Comment(text string) error {
+	return h.record("comment " + text)
+}
+
+func (h *recordingHandler) CDATA(text string) error {
+	return h.record("cdata " + text)
+}
+
+func (h *recordingHandler) ProcInst(target, inst string) error {
+	return h.record("pi " + target + " " + inst)
+}
+
+func (h *recordingHandler) Directive(text string) error {
+	return h.record("directive " + text)
+}
+
+const streamXML = `<?xml version="1.0"?><!DOCTYPE root><root a="1"><!--note-->text<![CDATA[<raw>]]><?php echo?></root>`
+
+func TestParseStreamReportsAllTokens(t *testing.T) {
+	h := &recordingHandler{}
+	if err := xmldom.ParseStream(strings.NewReader(streamXML), h); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		`pi xml version="1.0"`,
+		"directive DOCTYPE root",
+		"start root 1",
+		"comment note",
+		"text text",
+		"cdata <raw>",
+		"pi php echo",
+		"end root",
+	}
+	if strings.Join(h.events, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Unexpected events:\n got: %q\nwant: %q", h.events, expected)
+	}
+}
+
+func TestParseStreamAbortsOnHandlerError(t *testing.T) {
+	h := &recordingHandler{abort: "comment note"}
+	if err := xmldom.ParseStream(strings.NewReader(streamXML), h); err == nil || err.Error() != "abort" {
+		t.Fatalf("Expect the handler error but got %v", err)
+	}
+	if last := h.events[len(h.events)-1]; last != "comment note" {
+		t.Fatalf("Expect no events after the abort but got '%s'", last)
+	}
+}
+
+func TestStreamDecoderReturnsDocumentsInSequence(t *testing.T) {
+	input := `<?xml version="1.0"?><msg id="1"/>
+<?xml version="1.0"?><msg id="2"><body>text</body></msg>
+<!-- done -->
+`
+	d := xmldom.NewStreamDecoder(strings.NewReader(input))
+
+	for _, id := range []string{"1", "2"} {
+		doc, err := d.Next()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if doc.Root.GetAttributeValue("id") != id {
+			t.Fatalf("Expect message %s but got %s", id, doc.XML())
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Fatalf("Expect io.EOF after the last document but got %v", err)
+	}
+}
+
+func TestTreeBuilderBuildsTheDocument(t *testing.T) {
+	input := `<?xml version="1.0"?><!DOCTYPE root><?style x?><root xmlns:a="urn:a" id="1"><!--note--><a:item> one <![CDATA[<two>]]> </a:item><?php echo?></root>`
+	b := xmldom.NewTreeBuilder()
+	if err := xmldom.ParseStream(strings.NewReader(input), b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	built, parsed := b.Document(), xmldom.Must(xmldom.ParseXML(input))
+	if built.XML() != parsed.XML() {
+		t.Fatalf("Expect the same document as the parser\n got: %s\nwant: %s", built.XML(), parsed.XML())
+	}
+	if item := built.Root.FirstChild(); item.Document != built || item.Parent != built.Root {
+		t.Fatalf("Expect the nodes to point at the built document")
+	}
+}
+
+func TestTreeBuilderMatchesTheParser(t *testing.T) {
+	input := `<root xmlns="urn:d" xmlns:a="urn:a"><a:p xml:space="preserve"> kept <b/> </a:p><mixed> one <b/> two <?pi x?> three </mixed><x xmlns="urn:a"/></root><?after y?>`
+	b := xmldom.NewTreeBuilder()
+	if err := xmldom.ParseStream(strings.NewReader(input), b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	built, parsed := b.Document(), xmldom.Must(xmldom.ParseXML(input))
+	if built.XML() != parsed.XML() {
+		t.Fatalf("Expect the same document as the parser\n got: %s\nwant: %s", built.XML(), parsed.XML())
+	}
+	if len(built.Epilog) != 1 || built.Epilog[0].Name != "after" || built.ProcInst != "" {
+		t.Fatalf("Expect the instruction after the root in the epilog, got %v and %q", built.Epilog, built.ProcInst)
+	}
+	for _, name := range []string{"p", "mixed", "x"} {
+		got, want := built.Root.GetChild(name), parsed.Root.GetChild(name)
+		if got.Text != want.Text || fmt.Sprint(got.TextNodes()) != fmt.Sprint(want.TextNodes()) || got.Prefix != want.Prefix {
+			t.Fatalf("Expect <%s> as parsed, got %q %q %q, want %q %q %q", name,
+				got.Text, got.TextNodes(), got.Prefix, want.Text, want.TextNodes(), want.Prefix)
+		}
+	}
+}
+
+func TestTreeBuilderRejectsSecondRoot(t *testing.T) {
+	b := xmldom.NewTreeBuilder()
+	b.StartElement("", "one", nil)
+	b.EndElement("", "one")
+	if err := b.StartElement("", "two", nil); err == nil {
+		t.Fatalf("Expect an error for a second root")
+	}
+}
+
+func TestParseElementBuildsTheFirstMatch(t *testing.T) {
+	input := `<feed xmlns:dc="urn:dc"><entry/><metadata id="1"><dc:title>One</dc:title></metadata><metadata id="2"/><broken`
+	n, err := xmldom.ParseElement(strings.NewReader(input), "metadata")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n.XML() != `<metadata id="1" xmlns:dc="urn:dc"><dc:title>One</dc:title></metadata>` {
+		t.Fatalf("Unexpected element %s", n.XML())
+	}
+	if n.Document.Root != n || n.Parent != nil {
+		t.Fatalf("Expect the element to be the root of its own document")
+	}
+
+	if _, err := xmldom.ParseElement(strings.NewReader(`<feed><entry/></feed>`), "metadata"); err != xmldom.ErrNotFound {
+		t.Fatalf("Expect ErrNotFound but got %v", err)
+	}
+}
+
+func TestNewStreamDecoderDoesNotRead(t *testing.T) {
+	r, w := io.Pipe()
+	created := make(chan *xmldom.StreamDecoder)
+	go func() {
+		created <- xmldom.NewStreamDecoder(r)
+	}()
+	d := <-created
+
+	go func() {
+		w.Write([]byte(`<msg id="1"/>`))
+		w.Close()
+	}()
+	if doc, err := d.Next(); err != nil || doc.Root.GetAttributeValue("id") != "1" {
+		t.Fatalf("Expect the message but got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Package xmldomtest provides helpers for testing code that uses xmldom. It is kept separate, so
+// that the xmldom package itself does not depend on the testing package.
+package xmldomtest
+
+import (
+	"testing"
+
+	"github.com/rtenhove/go-xmldom"
+)
+
+// AssertRoundTrip verifies that the XML text survives a parse and serialize cycle. The text is
+// parsed and serialized, after which the result is parsed and serialized again; the test fails
+// when the two serialized, canonical, forms differ, or when either parse fails.
+func AssertRoundTrip(t testing.TB, xml string) {
+	t.Helper()
+
+	doc, err := xmldom.ParseXML(xml)
+	if err != nil {
+		t.Fatalf("parse of input failed: %v", err)
+	}
+	canonical := doc.XML()
+
+	doc, err = xmldom.ParseXML(canonical)
+	if err != nil {
+		t.Fatalf("parse of serialized input failed: %v\n%s", err, canonical)
+	}
+	if roundTrip := doc.XML(); roundTrip != canonical {
+		t.Fatalf("round trip changed the document:\n got: %s\nwant: %s", roundTrip, canonical)
+	}
+}
@@ -0,0 +1,16 @@
+package xmldomtest_test
+
+import (
+	"testing"
+
+	"github.com/rtenhove/go-xmldom/xmldomtest"
+)
+
+func TestAssertRoundTrip(t *testing.T) {
+	xmldomtest.AssertRoundTrip(t, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE root>
+<root xmlns:xlink="http://www.w3.org/1999/xlink">
+  <use xlink:href="#a" title="&quot;quoted&quot; &amp; escaped"/>
+  <text>a &lt; b</text>
+</root>`)
+}